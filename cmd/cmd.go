@@ -1,10 +1,70 @@
 package main
 
 import (
+	"context"
+	"github.com/Jarnpher553/gonfig/internal/logger"
 	"github.com/Jarnpher553/gonfig/internal/server"
+	"github.com/Jarnpher553/gonfig/internal/server/cmdflag"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	alog "github.com/lesismal/arpc/log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	s := server.New()
-	s.Serve()
+	logx := &logger.XLogger{}
+	logx.SetLevel(alog.LevelInfo)
+
+	settings := cmdflag.Parse(logx)
+	cfg, err := settings.ServerCfg()
+	if err != nil {
+		logx.Fatal("Server config: %s", err)
+	}
+	level, _ := settings.Level()
+	reloader, err := settings.Certs()
+	if err != nil {
+		logx.Fatal("Load tls: %s", err)
+	}
+	st, err := store.OpenLeveldbStore(settings.DataDir)
+	if err != nil {
+		logx.Fatal("Leveldb open: %s", err)
+	}
+
+	httpLogger := &logger.XLogger{}
+	httpLogger.SetLevel(level)
+	httpLogger.SetModName("HttpServer")
+	rpcLogger := &logger.XLogger{}
+	rpcLogger.SetLevel(level)
+
+	s, err := server.New(cfg, &server.Options{
+		Store:     st,
+		Logger:    httpLogger,
+		RpcLogger: rpcLogger,
+		Certs:     reloader,
+	})
+	if err != nil {
+		_ = st.Close()
+		logx.Fatal("New server: %s", err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		_ = st.Close()
+		logx.Fatal("Start server: %s", err)
+	}
+
+	notify := make(chan os.Signal, 1)
+	signal.Notify(notify, syscall.SIGINT, syscall.SIGTERM)
+	<-notify
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.Timeouts.Shutdown))
+	defer cancel()
+	err = s.Shutdown(ctx)
+	// the store was opened here, the server leaves it open
+	if cerr := st.Close(); cerr != nil {
+		logx.Error("Leveldb close: %s", cerr)
+	}
+	if err != nil {
+		logx.Fatal("Shutdown server: %s", err)
+	}
 }
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Jarnpher553/gonfig/component/metadata"
@@ -14,7 +16,9 @@ import (
 	"math"
 	"math/rand"
 	"net"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,11 +28,39 @@ type GfClient struct {
 	endpoints []string
 	cfgMeta   *metadata.ConfigMeta
 	outbound  chan string
+	deleted   chan struct{}
+	password  string
+	tls       *tls.Config
 }
 
 type Config struct {
 	Metadata  *metadata.ConfigMeta
 	Endpoints []string
+	// Password the servers are configured with, the built-in default if empty
+	Password string
+	// Key credential created on master for the application, it takes precedence over Password
+	Key string
+	// TLS dial the servers over tls with the config, its Certificates are presented to servers asking for one
+	TLS *tls.Config
+}
+
+// handler leaves the connected callbacks of the first connection out, arpc runs them in
+// its receive loop where an rpc call can't get its response. the first connection is
+// authenticated by the caller, reconnections run the callbacks in their own goroutine
+type handler struct {
+	arpc.Handler
+	connected int32
+}
+
+func (h *handler) Clone() arpc.Handler {
+	return &handler{Handler: h.Handler.Clone()}
+}
+
+func (h *handler) OnConnected(c *arpc.Client) {
+	if atomic.CompareAndSwapInt32(&h.connected, 0, 1) {
+		return
+	}
+	h.Handler.OnConnected(c)
 }
 
 func New(config *Config) (*GfClient, error) {
@@ -45,14 +77,21 @@ func New(config *Config) (*GfClient, error) {
 	arpc.DefaultHandler.SetLogTag("[" + color.Green("Gonfig") + "]")
 
 	cl, err := pubsub.NewClient(func() (net.Conn, error) {
-		return net.Dial("tcp", config.Endpoints[0])
-	})
+		return dial(config.Endpoints[0], config.TLS)
+	}, &handler{Handler: arpc.DefaultHandler})
 	if err != nil {
 		return nil, err
 	}
 	cl.Handler.SetLogTag("[" + color.Green("Gonfig") + "]")
 
-	cl.Password = types.PubSubPassword
+	password := config.Password
+	if config.Key != "" {
+		password = config.Key
+	}
+	if password == "" {
+		password = types.PubSubPassword
+	}
+	cl.Password = password
 	err = cl.Authenticate()
 	if err != nil {
 		return nil, err
@@ -65,6 +104,9 @@ func New(config *Config) (*GfClient, error) {
 		current:   0,
 		cfgMeta:   config.Metadata,
 		outbound:  outbound,
+		deleted:   make(chan struct{}, 1),
+		password:  password,
+		tls:       config.TLS,
 	}
 
 	cl.Handler.HandleConnected(func(client *arpc.Client) {
@@ -87,12 +129,92 @@ func (c *GfClient) Watch() chan string {
 	}
 
 	c.client.Subscribe(fmt.Sprintf(types.ConfigFormat, c.cfgMeta.Name, strings.Join(c.cfgMeta.Tags, "#")), c.subHandler, time.Second*30)
+	c.client.Subscribe(fmt.Sprintf(types.TombstoneFormat, c.cfgMeta.Name, strings.Join(c.cfgMeta.Tags, "#")), c.delHandler, time.Second*30)
 	return c.outbound
 }
 
+func dial(addr string, tlsCfg *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 30}
+	if tlsCfg != nil {
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// DiscoverOptions options of Discover
+type DiscoverOptions struct {
+	// TLS discover over https, the config verifies the http server of the node
+	TLS *tls.Config
+	// Key credential key, which the http server requires once its cluster token is set or a
+	// credential exists
+	Key string
+}
+
+// Discover rpc endpoints of the nodes in the cluster of the node whose http server is at addr,
+// suspect nodes are left out. the result can be used as Config.Endpoints. opts may be nil for
+// plain http without a key
+func Discover(addr string, opts *DiscoverOptions) ([]string, error) {
+	if opts == nil {
+		opts = &DiscoverOptions{}
+	}
+	hc, scheme := http.DefaultClient, "http"
+	if opts.TLS != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = opts.TLS
+		hc, scheme = &http.Client{Transport: transport}, "https"
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s://%s/cluster", scheme, addr), nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Key)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discover failure: %s", resp.Status)
+	}
+
+	var cluster types.ClusterResp
+	err = json.NewDecoder(resp.Body).Decode(&cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]string, 0)
+	var walk func(nodes []*types.NodeStatus)
+	walk = func(nodes []*types.NodeStatus) {
+		for _, node := range nodes {
+			if node.RPCAddr != "" && node.State != types.HealthSuspect {
+				endpoints = append(endpoints, node.RPCAddr)
+			}
+			walk(node.Downstream)
+		}
+	}
+	walk([]*types.NodeStatus{cluster.Master})
+	walk(cluster.Slaves)
+	return endpoints, nil
+}
+
+// Deleted return a channel notified when the watched config is deleted
+func (c *GfClient) Deleted() chan struct{} {
+	return c.deleted
+}
+
 func (c *GfClient) subHandler(topic *pubsub.Topic) {
 	c.outbound <- string(topic.Data)
 }
+
+func (c *GfClient) delHandler(topic *pubsub.Topic) {
+	select {
+	case c.deleted <- struct{}{}:
+	default:
+	}
+}
 func (c *GfClient) disconnectedHandler(client *arpc.Client) {
 	r := rand.New(rand.NewSource(time.Now().Unix()))
 	var n int
@@ -107,14 +229,14 @@ func (c *GfClient) disconnectedHandler(client *arpc.Client) {
 
 	retry.Retry(math.MaxInt32, func() error {
 		cl, err := pubsub.NewClient(func() (net.Conn, error) {
-			return net.DialTimeout("tcp", c.endpoints[n], time.Second*30)
-		})
+			return dial(c.endpoints[n], c.tls)
+		}, &handler{Handler: arpc.DefaultHandler})
 		if err != nil {
 			return err
 		}
 		cl.Handler.SetLogTag("[" + color.Green("Gonfig") + "]")
 
-		cl.Password = types.PubSubPassword
+		cl.Password = c.password
 		err = cl.Authenticate()
 		if err != nil {
 			return err
@@ -5,8 +5,18 @@ import (
 	"github.com/Jarnpher553/gonfig/internal/util/color"
 	alog "github.com/lesismal/arpc/log"
 	"log"
+	"os"
 )
 
+//Logger leveled logger, it is compatible with arpc's logger
+type Logger interface {
+	SetLevel(lvl int)
+	Debug(format string, v ...interface{})
+	Info(format string, v ...interface{})
+	Warn(format string, v ...interface{})
+	Error(format string, v ...interface{})
+}
+
 type XLogger struct {
 	level int
 	mod   string
@@ -71,4 +81,5 @@ func (l *XLogger) Fatal(format string, v ...interface{}) {
 	} else {
 		log.Printf(fmt.Sprintf("[%s] %s", color.Green("FATAL"), format), v...)
 	}
+	os.Exit(1)
 }
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/merkle"
+	"github.com/Jarnpher553/gonfig/internal/server/replication"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const antiEntropyInterval = time.Minute
+
+//antiEntropy compare the digest of every slave with master's until stop is closed,
+//so slaves drifted by a lost sync are healed without a full re-push
+func (s *Server) antiEntropy(stop <-chan struct{}) {
+	t := time.NewTicker(antiEntropyInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		if s.consensus != nil && !s.consensus.IsLeader() {
+			continue
+		}
+		err := s.reconcile()
+		if err != nil {
+			s.logger.Info("Anti-entropy: %s", err)
+		}
+	}
+}
+
+//reconcile run one anti-entropy round against the slaves which applied master's revision,
+//lagging slaves are left to the sync path
+func (s *Server) reconcile() error {
+	digest, err := merkle.Digest(s.store)
+	if err != nil {
+		return err
+	}
+	current, err := history.Current(s.store)
+	if err != nil {
+		return err
+	}
+	if current != digest.Revision {
+		return nil
+	}
+
+	s.mux.Lock()
+	slaves := make([]*types.ServerMetadata, 0, len(*s.slaves))
+	for _, slave := range *s.slaves {
+		if slave.Revision == current {
+			slaves = append(slaves, slave)
+		}
+	}
+	s.mux.Unlock()
+
+	g := sync.WaitGroup{}
+	g.Add(len(slaves))
+	for _, slave := range slaves {
+		go func(sl *types.ServerMetadata) {
+			defer g.Done()
+			repaired, err := s.reconcileSlave(sl, digest)
+			if err != nil {
+				s.logger.Info("Slave id:[%s] addr:[%s] anti-entropy error: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
+				return
+			}
+			if repaired < 0 {
+				return
+			}
+
+			s.mux.Lock()
+			s.antiEntropyStatus.Divergent++
+			s.antiEntropyStatus.Repaired += uint64(repaired)
+			sl.Repaired += uint64(repaired)
+			s.mux.Unlock()
+		}(slave)
+	}
+	g.Wait()
+
+	s.mux.Lock()
+	s.antiEntropyStatus.Rounds++
+	s.antiEntropyStatus.LastRound = time.Now().Unix()
+	s.mux.Unlock()
+	return nil
+}
+
+//reconcileSlave repair the buckets whose hashes differ between master and slave,
+//it returns the count of configs repaired, or -1 if the slave did not diverge
+func (s *Server) reconcileSlave(sl *types.ServerMetadata, digest *types.Digest) (int, error) {
+	resp, err := s.peer.Get(s.peer.URL(sl.RAddr, "/digest"))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New("digest failure")
+	}
+	var remote types.Digest
+	err = json.NewDecoder(resp.Body).Decode(&remote)
+	if err != nil {
+		return 0, err
+	}
+	if remote.Revision != digest.Revision {
+		return -1, nil
+	}
+
+	buckets := merkle.Diff(digest, &remote)
+	if len(buckets) == 0 {
+		return -1, nil
+	}
+
+	req, err := replication.Range(s.store, buckets)
+	if err != nil {
+		return 0, err
+	}
+	// master moved on since the digest, the next round compares again
+	if req.Revision != digest.Revision {
+		return -1, nil
+	}
+	jsonBytes, err := json.Marshal(req)
+	if err != nil {
+		return 0, err
+	}
+	repairResp, err := s.peer.Post(s.peer.URL(sl.RAddr, "/repair"), "application:json", strings.NewReader(string(jsonBytes)))
+	if err != nil {
+		return 0, err
+	}
+	defer repairResp.Body.Close()
+	// a sync landed on the slave since its digest, repairing would revert it
+	if repairResp.StatusCode == http.StatusConflict {
+		return -1, nil
+	}
+	if repairResp.StatusCode != http.StatusOK {
+		return 0, errors.New("repair failure")
+	}
+	var repair types.RepairResp
+	err = json.NewDecoder(repairResp.Body).Decode(&repair)
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Info("Slave id:[%s] addr:[%s] anti-entropy repaired configs:[%s] buckets:[%s]", color.Green(sl.ID), color.Green(sl.RAddr), color.Green(repair.Repaired), color.Green(len(buckets)))
+	return repair.Repaired, nil
+}
@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"io"
+	"path"
+	"strconv"
+)
+
+//ErrInvalidFilter filter has a malformed name pattern
+var ErrInvalidFilter = errors.New("invalid audit filter")
+
+//Record append entry to the audit log and assign its sequence number, entries are never
+//changed or deleted
+func Record(st store.Store, entry *types.AuditEntry) error {
+	// entries are appended one at a time so that sequence numbers have no gap
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+	return Append(st, entry)
+}
+
+//Append Record for a caller holding st.CommitLock(), config writes append their entry in the
+//commit which makes them
+func Append(st store.Store, entry *types.AuditEntry) error {
+	seq, err := Sequence(st)
+	if err != nil {
+		return err
+	}
+	entry.Seq = seq + 1
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = st.Put([]byte(fmt.Sprintf(types.AuditFormat, entry.Seq)), entryBytes)
+	if err != nil {
+		return err
+	}
+	return st.Put([]byte(types.AuditSequenceKey), []byte(strconv.FormatUint(entry.Seq, 10)))
+}
+
+//Sequence sequence number of the last entry, zero if nothing has been recorded
+func Sequence(st store.Store) (uint64, error) {
+	v, err := st.Get([]byte(types.AuditSequenceKey))
+	if err == store.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(v), 10, 64)
+}
+
+//Query entries matching req after its cursor, at most limit of them. next is the cursor of
+//the following page, zero if there is none
+func Query(st store.Store, req *types.AuditReq, limit int) (entries []*types.AuditEntry, next uint64, err error) {
+	entries = make([]*types.AuditEntry, 0)
+	err = scan(st, req, func(e *types.AuditEntry) bool {
+		if len(entries) == limit {
+			next = entries[len(entries)-1].Seq
+			return false
+		}
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, next, nil
+}
+
+//Export write the entries matching req after its cursor to w as json lines
+func Export(st store.Store, req *types.AuditReq, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	var werr error
+	err := scan(st, req, func(e *types.AuditEntry) bool {
+		werr = enc.Encode(e)
+		return werr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
+
+//scan call f with the entries matching req in sequence order until f returns false. sequence
+//numbers have no gap, entries are read one by one from the cursor on, so a page without a
+//filter costs its own size rather than the size of the log
+func scan(st store.Store, req *types.AuditReq, f func(e *types.AuditEntry) bool) error {
+	if req.Name != "" {
+		if _, err := path.Match(req.Name, ""); err != nil {
+			return ErrInvalidFilter
+		}
+	}
+	last, err := Sequence(st)
+	if err != nil {
+		return err
+	}
+
+	for seq := req.Cursor + 1; seq <= last; seq++ {
+		v, err := st.Get([]byte(fmt.Sprintf(types.AuditFormat, seq)))
+		if err != nil {
+			return err
+		}
+		var e types.AuditEntry
+		err = json.Unmarshal(v, &e)
+		if err != nil {
+			return err
+		}
+		if !match(req, &e) {
+			continue
+		}
+		if !f(&e) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func match(req *types.AuditReq, e *types.AuditEntry) bool {
+	if req.Since != 0 && e.Timestamp < req.Since {
+		return false
+	}
+	if req.Until != 0 && e.Timestamp >= req.Until {
+		return false
+	}
+	if req.Name != "" {
+		if ok, _ := path.Match(req.Name, e.Name); !ok {
+			return false
+		}
+	}
+	for _, want := range req.Tags {
+		found := false
+		for _, t := range e.Tag {
+			if t == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	st := store.NewMapStore()
+	entries := []*types.AuditEntry{
+		{Timestamp: 100, Op: types.AuditPush, Name: "payment-service", Tag: []string{"prod"}, Actor: "alice", Revision: 1, Hash: "a"},
+		{Timestamp: 200, Op: types.AuditPush, Name: "web", Actor: "bob", Revision: 1, Hash: "b"},
+		{Timestamp: 300, Op: types.AuditPush, Name: "payment-service", Tag: []string{"prod"}, Actor: "alice", Revision: 2, PrevHash: "a", Hash: "c"},
+		{Timestamp: 400, Op: types.AuditDelete, Name: "payment-service", Tag: []string{"dev"}, Actor: "carol", Revision: 1},
+	}
+	for _, e := range entries {
+		if err := Record(st, e); err != nil {
+			t.FailNow()
+		}
+	}
+	if seq, _ := Sequence(st); seq != 4 {
+		t.FailNow()
+	}
+
+	t.Run("Audit_Query", func(t *testing.T) {
+		tests := []struct {
+			req  types.AuditReq
+			seqs []uint64
+		}{
+			{types.AuditReq{}, []uint64{1, 2, 3, 4}},
+			{types.AuditReq{Name: "payment-*"}, []uint64{1, 3, 4}},
+			{types.AuditReq{Name: "payment-service", Tags: []string{"prod"}}, []uint64{1, 3}},
+			{types.AuditReq{Since: 200, Until: 400}, []uint64{2, 3}},
+			{types.AuditReq{Cursor: 2}, []uint64{3, 4}},
+		}
+		for i, test := range tests {
+			t.Run(fmt.Sprintf("Query_%d", i), func(t *testing.T) {
+				got, next, err := Query(st, &test.req, 10)
+				if err != nil || next != 0 || len(got) != len(test.seqs) {
+					t.FailNow()
+				}
+				for j, e := range got {
+					if e.Seq != test.seqs[j] {
+						t.FailNow()
+					}
+				}
+			})
+		}
+	})
+	t.Run("Audit_Page", func(t *testing.T) {
+		got, next, err := Query(st, &types.AuditReq{Name: "payment-*"}, 2)
+		if err != nil || len(got) != 2 || next != 3 {
+			t.FailNow()
+		}
+		got, next, err = Query(st, &types.AuditReq{Name: "payment-*", Cursor: next}, 2)
+		if err != nil || len(got) != 1 || got[0].Seq != 4 || next != 0 {
+			t.FailNow()
+		}
+		if _, _, err := Query(st, &types.AuditReq{Name: "["}, 2); err != ErrInvalidFilter {
+			t.FailNow()
+		}
+	})
+	t.Run("Audit_Export", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Export(st, &types.AuditReq{Since: 300}, &buf); err != nil {
+			t.FailNow()
+		}
+		sc := bufio.NewScanner(&buf)
+		lines := 0
+		for sc.Scan() {
+			var e types.AuditEntry
+			if json.Unmarshal(sc.Bytes(), &e) != nil || e.Seq != uint64(lines+3) {
+				t.FailNow()
+			}
+			lines++
+		}
+		if lines != 2 {
+			t.FailNow()
+		}
+	})
+	t.Run("Audit_ReadFailure", func(t *testing.T) {
+		e := &types.AuditEntry{Timestamp: 500, Op: types.AuditPush, Name: "web", Actor: "bob", Revision: 2}
+		if err := Record(unreadable{st}, e); err == nil {
+			t.FailNow()
+		}
+		if seq, _ := Sequence(st); seq != 4 {
+			t.FailNow()
+		}
+		got, _, err := Query(st, &types.AuditReq{}, 10)
+		if err != nil || len(got) != 4 || got[0].Actor != "alice" {
+			t.FailNow()
+		}
+	})
+}
+
+//unreadable store whose reads fail
+type unreadable struct {
+	store.Store
+}
+
+func (unreadable) Get([]byte) ([]byte, error) {
+	return nil, errors.New("read failure")
+}
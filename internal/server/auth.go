@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
+	"github.com/Jarnpher553/gonfig/internal/server/rpchandler"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
+	"github.com/lesismal/arpc"
+)
+
+const (
+	//routeAuthenticate pubsub route rpc clients authenticate on
+	routeAuthenticate = "in_A"
+	//routeSubscribe pubsub route rpc clients subscribe on
+	routeSubscribe = "in_S"
+	//routePublish pubsub route publishing to every subscriber of a topic
+	routePublish = "in_P"
+	//routePublishToOne pubsub route publishing to one subscriber of a topic
+	routePublishToOne = "in_P1"
+)
+
+//authCoder check the key of authenticating rpc clients against the credential set,
+//an accepted request is rewritten to carry the pubsub server's internal password
+//and a rejected one an empty password. subscriptions to configs the client's
+//credential isn't granted are rewritten to an empty topic, which pubsub rejects.
+//clients never publish, only the server does through psServer.Publish, so their
+//publish requests are rewritten to an empty topic too
+type authCoder struct {
+	s *Server
+	//password explicitly configured shared password, accepted besides credentials
+	password string
+	//internal password of the pubsub server, never leaves the process
+	internal string
+}
+
+func newAuthCoder(s *Server, password string) *authCoder {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return &authCoder{s: s, password: password, internal: hex.EncodeToString(buf)}
+}
+
+//Encode leave messages sent to clients as they are
+func (a *authCoder) Encode(c *arpc.Client, msg *arpc.Message) *arpc.Message {
+	return msg
+}
+
+//Decode rewrite authenticate, subscribe and publish requests
+func (a *authCoder) Decode(c *arpc.Client, msg *arpc.Message) *arpc.Message {
+	// pubsub handles publish notifies as it handles requests
+	switch msg.Method() {
+	case routePublish, routePublishToOne:
+		return a.publish(c, msg)
+	}
+	if msg.Cmd() != arpc.CmdRequest {
+		return msg
+	}
+	switch msg.Method() {
+	case routeAuthenticate:
+		return a.authenticate(c, msg)
+	case routeSubscribe:
+		return a.subscribe(c, msg)
+	}
+	return msg
+}
+
+func (a *authCoder) authenticate(c *arpc.Client, msg *arpc.Message) *arpc.Message {
+	password := ""
+	app, ok := a.verify(string(msg.Data()))
+	if ok {
+		password = a.internal
+		c.Set(types.AppKey, app)
+	} else {
+		c.Delete(types.AppKey)
+		a.s.rpcLogger.Warn("%s Authenticate rejected from:[%s]", a.s.psServer.Handler.LogTag(), color.Green(c.Conn.RemoteAddr()))
+	}
+	return arpc.NewMessage(arpc.CmdRequest, routeAuthenticate, password, false, msg.IsAsync(), msg.Seq(), c.Handler, c.Codec, msg.Values())
+}
+
+func (a *authCoder) subscribe(c *arpc.Client, msg *arpc.Message) *arpc.Message {
+	app, ok := rpchandler.App(c)
+	if !ok {
+		// pubsub rejects clients which have not authenticated
+		return msg
+	}
+	topic := topicName(msg.Data())
+	name, tags := types.ParseConfigKey(topic)
+	if credential.Allowed(a.s.store, app, name, tags) {
+		return msg
+	}
+	a.s.rpcLogger.Warn("%s Subscribe topic:[%s] app:[%s] from:[%s] denied", a.s.psServer.Handler.LogTag(), color.Green(topic), color.Green(app), color.Green(c.Conn.RemoteAddr()))
+	return arpc.NewMessage(arpc.CmdRequest, routeSubscribe, nil, false, msg.IsAsync(), msg.Seq(), c.Handler, c.Codec, msg.Values())
+}
+
+func (a *authCoder) publish(c *arpc.Client, msg *arpc.Message) *arpc.Message {
+	app, _ := rpchandler.App(c)
+	a.s.rpcLogger.Warn("%s Publish topic:[%s] app:[%s] from:[%s] rejected", a.s.psServer.Handler.LogTag(), color.Green(topicName(msg.Data())), color.Green(app), color.Green(c.Conn.RemoteAddr()))
+	return arpc.NewMessage(msg.Cmd(), msg.Method(), nil, false, msg.IsAsync(), msg.Seq(), c.Handler, c.Codec, msg.Values())
+}
+
+//topicName name of the topic encoded by pubsub as data, name, uint16 name length and int64 timestamp
+func topicName(data []byte) string {
+	if len(data) < 10 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint16(data[len(data)-10:]))
+	if n > len(data)-10 {
+		return ""
+	}
+	return string(data[len(data)-10-n : len(data)-10])
+}
+
+//verify application authenticated by key, empty for the shared password. while neither a
+//password is configured nor any application has a credential, the built-in password and an
+//empty key are accepted
+func (a *authCoder) verify(key string) (string, bool) {
+	if app, ok := credential.Verify(a.s.store, key); ok {
+		return app, true
+	}
+	if a.password != "" {
+		return "", subtle.ConstantTimeCompare([]byte(key), []byte(a.password)) == 1
+	}
+	return "", (key == "" || key == types.PubSubPassword) && credential.Empty(a.s.store)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"github.com/lesismal/arpc"
+	"github.com/lesismal/arpc/codec"
+	"net"
+	"testing"
+)
+
+func TestAuthCoder(t *testing.T) {
+	s, err := New(&types.ServerCfg{Addr: "127.0.0.1:8888", Role: types.RoleMaster}, &Options{Store: store.NewMapStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	c := &arpc.Client{Conn: conn, Handler: arpc.DefaultHandler, Codec: codec.DefaultCodec}
+
+	// topic bytes as pubsub encodes them, data, name, uint16 name length and int64 timestamp
+	name := types.ConfigKey("web", []string{"prod"})
+	topic := append([]byte("forged"), name...)
+	topic = append(topic, make([]byte, 10)...)
+	binary.LittleEndian.PutUint16(topic[len(topic)-10:], uint16(len(name)))
+
+	tests := []struct {
+		cmd    byte
+		method string
+		empty  bool
+	}{
+		{arpc.CmdRequest, routePublish, true},
+		{arpc.CmdNotify, routePublish, true},
+		{arpc.CmdRequest, routePublishToOne, true},
+		{arpc.CmdRequest, "/echo", false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("AuthCoder_%d", i), func(t *testing.T) {
+			msg := arpc.NewMessage(test.cmd, test.method, topic, false, false, uint64(i), c.Handler, c.Codec, nil)
+			out := s.auth.Decode(c, msg)
+			if out.Method() != test.method || (len(out.Data()) == 0) != test.empty {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestAuthVerify(t *testing.T) {
+	s, err := New(&types.ServerCfg{Addr: "127.0.0.1:8888", Role: types.RoleMaster}, &Options{Store: store.NewMapStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		key      string
+		ok       bool
+	}{
+		{"", "", true},
+		{"", types.PubSubPassword, true},
+		{"", "secret", false},
+		{"secret", "secret", true},
+		{"secret", "secre", false},
+		{"secret", "", false},
+		{"secret", types.PubSubPassword, false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("AuthVerify_%d", i), func(t *testing.T) {
+			if _, ok := newAuthCoder(s, test.password).verify(test.key); ok != test.ok {
+				t.FailNow()
+			}
+		})
+	}
+}
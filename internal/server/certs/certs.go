@@ -0,0 +1,177 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Reloader certificate, key and ca files of a node which are loaded again when they change,
+//so certificates can be rotated without restarting the node
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mux  sync.RWMutex
+	cert *tls.Certificate
+	//pool ca verifying the other nodes, nil for the system roots
+	pool *x509.CertPool
+	//stamp modification times and sizes of the files last loaded
+	stamp string
+}
+
+//New construct Reloader and load the files, caFile may be empty
+func New(certFile string, keyFile string, caFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls cert and key must be set together")
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	_, err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Mutual whether a ca is set, nodes then have to present a certificate it signed to each other
+func (r *Reloader) Mutual() bool {
+	return r.caFile != ""
+}
+
+//Reload load the files again if they changed since last loaded, a failed reload keeps the
+//certificates loaded before
+func (r *Reloader) Reload() (bool, error) {
+	stamp, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+	r.mux.RLock()
+	unchanged := stamp == r.stamp
+	r.mux.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pem, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return false, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+	}
+
+	r.mux.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.stamp = stamp
+	r.mux.Unlock()
+	return true, nil
+}
+
+func (r *Reloader) stat() (string, error) {
+	stamps := make([]string, 0, 3)
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		stamps = append(stamps, fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(stamps, ","), nil
+}
+
+//Watch reload the files every interval until stop is closed
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}, log logger.Logger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		reloaded, err := r.Reload()
+		if err != nil {
+			log.Error("Reload tls cert:[%s] failure: %s", color.Green(r.certFile), err)
+			continue
+		}
+		if reloaded {
+			log.Info("Reload tls cert:[%s] success", color.Green(r.certFile))
+		}
+	}
+}
+
+func (r *Reloader) certificate() *tls.Certificate {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.cert
+}
+
+func (r *Reloader) roots() *x509.CertPool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.pool
+}
+
+//Config tls config serving and dialing with the current certificates. as a server it asks
+//for a client certificate when a ca is set, as a client it verifies servers against the
+//current ca itself since the ca can't be changed in a config used by a transport
+func (r *Reloader) Config() *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return r.certificate(), nil
+	}
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{GetCertificate: getCertificate}
+			if pool := r.roots(); pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate(), nil
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verifyServer,
+	}
+}
+
+//verifyServer verify the certificate of the server a node dialed
+func (r *Reloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server has no certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.roots(),
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -0,0 +1,140 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type pair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issue(t *testing.T, serial int64, parent *pair) *pair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.FailNow()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "gonfig"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &pair{cert: cert, key: key, der: der}
+}
+
+func write(t *testing.T, p *pair, certFile string, keyFile string) {
+	keyDer, _ := x509.MarshalECPrivateKey(p.key)
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.der}), 0644)
+	if keyFile != "" {
+		_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	}
+}
+
+//handshake whether the client's certificate is verified by the server
+func handshake(t *testing.T, server *tls.Config, client *tls.Config) bool {
+	sc, cc := net.Pipe()
+	done := make(chan bool, 1)
+	go func() {
+		conn := tls.Server(sc, server)
+		if conn.Handshake() != nil {
+			done <- false
+			return
+		}
+		done <- len(conn.ConnectionState().VerifiedChains) != 0
+	}()
+	conn := tls.Client(cc, client)
+	err := conn.Handshake()
+	verified := <-done
+	if err != nil {
+		t.FailNow()
+	}
+	return verified
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gonfig")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "node.pem"), filepath.Join(dir, "node-key.pem"), filepath.Join(dir, "ca.pem")
+
+	ca := issue(t, 1, nil)
+	write(t, ca, caFile, "")
+	write(t, issue(t, 2, ca), certFile, keyFile)
+
+	r, err := New(certFile, keyFile, caFile)
+	if err != nil || !r.Mutual() {
+		t.FailNow()
+	}
+	t.Run("Reloader_Mutual", func(t *testing.T) {
+		client := r.Config()
+		client.ServerName = "127.0.0.1"
+		if !handshake(t, r.Config(), client) {
+			t.FailNow()
+		}
+		anonymous := &tls.Config{RootCAs: x509.NewCertPool()}
+		anonymous.RootCAs.AddCert(ca.cert)
+		anonymous.ServerName = "127.0.0.1"
+		if handshake(t, r.Config(), anonymous) {
+			t.FailNow()
+		}
+	})
+	t.Run("Reloader_Reload", func(t *testing.T) {
+		if reloaded, err := r.Reload(); err != nil || reloaded {
+			t.FailNow()
+		}
+		write(t, issue(t, 3, ca), certFile, keyFile)
+		later := time.Now().Add(time.Minute)
+		_ = os.Chtimes(certFile, later, later)
+		if reloaded, err := r.Reload(); err != nil || !reloaded {
+			t.FailNow()
+		}
+		leaf, _ := x509.ParseCertificate(r.certificate().Certificate[0])
+		if leaf.SerialNumber.Int64() != 3 {
+			t.FailNow()
+		}
+	})
+	t.Run("Reloader_Broken", func(t *testing.T) {
+		_ = ioutil.WriteFile(keyFile, []byte("broken"), 0600)
+		later := time.Now().Add(2 * time.Minute)
+		_ = os.Chtimes(keyFile, later, later)
+		if _, err := r.Reload(); err == nil {
+			t.FailNow()
+		}
+		leaf, _ := x509.ParseCertificate(r.certificate().Certificate[0])
+		if leaf.SerialNumber.Int64() != 3 {
+			t.FailNow()
+		}
+	})
+}
@@ -1,87 +1,445 @@
 package cmdflag
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/server/certs"
 	"github.com/Jarnpher553/gonfig/internal/server/types"
 	ipAddr "github.com/Jarnpher553/gonfig/internal/util/addr"
-	"net"
+	alog "github.com/lesismal/arpc/log"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
-var role = flag.String("role", "master", "server role")
-var addr = flag.String("addr", ":9019", "server addr")
-var master = flag.String("master", "", "master addr")
+//EnvPrefix prefix of the env vars overriding settings, -data-dir is read from GONFIG_DATA_DIR
+const EnvPrefix = "GONFIG_"
 
-func Parse(log *logger.XLogger) *types.ServerCfg {
-	c := &types.ServerCfg{}
+//Duration time.Duration written as "10s" in config files
+type Duration time.Duration
 
-	flag.Parse()
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	return d.parse(v)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v string
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return d.parse(v)
+}
+
+func (d *Duration) parse(v string) error {
+	t, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(t)
+	return nil
+}
+
+//Settings every tunable of a server, taken in order from the defaults, the config file,
+//GONFIG_* env vars and flags, a later source overrides an earlier one
+type Settings struct {
+	Role string `yaml:"role" json:"role"`
+	Addr string `yaml:"addr" json:"addr"`
+	//RPCAddr listen address of the rpc server, the port next to Addr's if empty
+	RPCAddr string   `yaml:"rpc_addr" json:"rpc_addr"`
+	Master  string   `yaml:"master" json:"master"`
+	Mode    string   `yaml:"mode" json:"mode"`
+	Peers   []string `yaml:"peers" json:"peers"`
+	Relay   bool     `yaml:"relay" json:"relay"`
+	//DataDir directory of the leveldb store
+	DataDir string `yaml:"data_dir" json:"data_dir"`
+	//LogLevel debug, info, warn or error
+	LogLevel string       `yaml:"log_level" json:"log_level"`
+	Auth     AuthSettings `yaml:"auth" json:"auth"`
+	TLS      TLSSettings  `yaml:"tls" json:"tls"`
+	HTTP     HTTPSettings `yaml:"http" json:"http"`
+	Timeouts struct {
+		Read     Duration `yaml:"read" json:"read"`
+		Write    Duration `yaml:"write" json:"write"`
+		Shutdown Duration `yaml:"shutdown" json:"shutdown"`
+	} `yaml:"timeouts" json:"timeouts"`
+	Health struct {
+		Interval Duration `yaml:"interval" json:"interval"`
+		Timeout  Duration `yaml:"timeout" json:"timeout"`
+		Failures int      `yaml:"failures" json:"failures"`
+		Grace    Duration `yaml:"grace" json:"grace"`
+	} `yaml:"health" json:"health"`
+}
+
+//AuthSettings authentication of rpc clients and of the http api
+type AuthSettings struct {
+	Password string `yaml:"password" json:"password"`
+	//Token cluster token shared by the nodes, the http api requires tokens when it is set
+	Token string `yaml:"token" json:"token"`
+}
+
+//TLSSettings certificate files, tls is off unless Cert and Key are set. the files are
+//reloaded when they change
+type TLSSettings struct {
+	Cert string `yaml:"cert" json:"cert"`
+	Key  string `yaml:"key" json:"key"`
+	//CA verifies the certificates of the other nodes, which must present one it signed
+	//on the routes between nodes. the system roots are used if empty
+	CA string `yaml:"ca" json:"ca"`
+}
 
-	serverRole := types.Role(strings.Title(strings.ToLower(*role)))
+//HTTPSettings limits of the http api and the origins browsers may call it from
+type HTTPSettings struct {
+	//MaxBody max bytes of request bodies from clients, no limit if negative
+	MaxBody int64 `yaml:"max_body" json:"max_body"`
+	//CORSOrigins origins browsers may call the api from, "*" allows any, cors is off if empty
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins"`
+}
 
-	if serverRole != types.RoleMaster && serverRole != types.RoleSlave {
-		log.Fatal("Role hasn't be master or slave")
+//Defaults settings used when no source sets them
+func Defaults() *Settings {
+	s := &Settings{
+		Role:     "master",
+		Addr:     ":9019",
+		Mode:     types.ModeStatic,
+		DataDir:  "./db",
+		LogLevel: "info",
 	}
+	s.HTTP.MaxBody = types.DefaultMaxBodyBytes
+	s.Timeouts.Read = Duration(30 * time.Second)
+	s.Timeouts.Write = Duration(30 * time.Second)
+	s.Timeouts.Shutdown = Duration(5 * time.Second)
+	health := types.DefaultHealthCfg()
+	s.Health.Interval = Duration(health.Interval)
+	s.Health.Timeout = Duration(health.Timeout)
+	s.Health.Failures = health.FailureThreshold
+	s.Health.Grace = Duration(health.Grace)
+	return s
+}
 
-	if serverRole != types.RoleMaster && *master == "" {
-		log.Fatal("Master address of slave peer can't be empty")
+//setting flag bound to a field of Settings
+type setting struct {
+	name    string
+	usage   string
+	boolean bool
+	get     func(s *Settings) string
+	set     func(s *Settings, v string) error
+}
+
+func stringSetting(name string, usage string, field func(s *Settings) *string) *setting {
+	return &setting{
+		name:  name,
+		usage: usage,
+		get:   func(s *Settings) string { return *field(s) },
+		set: func(s *Settings, v string) error {
+			*field(s) = v
+			return nil
+		},
 	}
+}
 
-	c.Role = serverRole
+func durationSetting(name string, usage string, field func(s *Settings) *Duration) *setting {
+	return &setting{
+		name:  name,
+		usage: usage,
+		get:   func(s *Settings) string { return field(s).String() },
+		set:   func(s *Settings, v string) error { return field(s).parse(v) },
+	}
+}
 
-	var addrIP string
-	var addrPort string
-	if !strings.Contains(*addr, ":") {
-		log.Fatal("Server address format error")
-	} else {
-		splitAddr := strings.Split(*addr, ":")
-		if splitAddr[0] != "" {
-			ip := net.ParseIP(splitAddr[0])
-			if ip == nil {
-				log.Fatal("Server ip format error")
+var settings = []*setting{
+	stringSetting("role", "server role, master or slave", func(s *Settings) *string { return &s.Role }),
+	stringSetting("addr", "server addr", func(s *Settings) *string { return &s.Addr }),
+	stringSetting("rpc-addr", "rpc server addr, the port next to -addr's if empty", func(s *Settings) *string { return &s.RPCAddr }),
+	stringSetting("master", "master addr", func(s *Settings) *string { return &s.Master }),
+	stringSetting("mode", "cluster mode, static or raft", func(s *Settings) *string { return &s.Mode }),
+	{
+		name:  "peers",
+		usage: "comma separated addrs of the other nodes in raft mode",
+		get:   func(s *Settings) string { return strings.Join(s.Peers, ",") },
+		set: func(s *Settings, v string) error {
+			s.Peers = nil
+			if v != "" {
+				s.Peers = strings.Split(v, ",")
+			}
+			return nil
+		},
+	},
+	{
+		name:    "relay",
+		usage:   "slave accepts downstream slaves and fans out the changes it receives",
+		boolean: true,
+		get:     func(s *Settings) string { return strconv.FormatBool(s.Relay) },
+		set: func(s *Settings, v string) (err error) {
+			s.Relay, err = strconv.ParseBool(v)
+			return err
+		},
+	},
+	stringSetting("data-dir", "directory of the leveldb store", func(s *Settings) *string { return &s.DataDir }),
+	stringSetting("log-level", "log level, debug, info, warn or error", func(s *Settings) *string { return &s.LogLevel }),
+	stringSetting("password", "shared password rpc clients may authenticate with besides credentials", func(s *Settings) *string { return &s.Auth.Password }),
+	stringSetting("token", "cluster token shared by the nodes, enables authentication of the http api", func(s *Settings) *string { return &s.Auth.Token }),
+	stringSetting("tls-cert", "certificate file, enables tls with -tls-key", func(s *Settings) *string { return &s.TLS.Cert }),
+	stringSetting("tls-key", "private key file of -tls-cert", func(s *Settings) *string { return &s.TLS.Key }),
+	stringSetting("tls-ca", "ca file verifying the other nodes, enables mutual tls between nodes", func(s *Settings) *string { return &s.TLS.CA }),
+	{
+		name:  "max-body",
+		usage: "max bytes of request bodies from clients, no limit if negative",
+		get:   func(s *Settings) string { return strconv.FormatInt(s.HTTP.MaxBody, 10) },
+		set: func(s *Settings, v string) (err error) {
+			s.HTTP.MaxBody, err = strconv.ParseInt(v, 10, 64)
+			return err
+		},
+	},
+	{
+		name:  "cors-origins",
+		usage: "comma separated origins browsers may call the http api from, * allows any",
+		get:   func(s *Settings) string { return strings.Join(s.HTTP.CORSOrigins, ",") },
+		set: func(s *Settings, v string) error {
+			s.HTTP.CORSOrigins = nil
+			if v != "" {
+				s.HTTP.CORSOrigins = strings.Split(v, ",")
 			}
+			return nil
+		},
+	},
+	durationSetting("read-timeout", "timeout of reading http requests", func(s *Settings) *Duration { return &s.Timeouts.Read }),
+	durationSetting("write-timeout", "timeout of writing http responses", func(s *Settings) *Duration { return &s.Timeouts.Write }),
+	durationSetting("shutdown-timeout", "timeout of graceful shutdown", func(s *Settings) *Duration { return &s.Timeouts.Shutdown }),
+	durationSetting("health-interval", "interval between health checks of a slave", func(s *Settings) *Duration { return &s.Health.Interval }),
+	durationSetting("health-timeout", "timeout of a single health check", func(s *Settings) *Duration { return &s.Health.Timeout }),
+	{
+		name:  "health-failures",
+		usage: "consecutive failed health checks before a slave is suspect",
+		get:   func(s *Settings) string { return strconv.Itoa(s.Health.Failures) },
+		set: func(s *Settings, v string) (err error) {
+			s.Health.Failures, err = strconv.Atoi(v)
+			return err
+		},
+	},
+	durationSetting("health-grace", "duration a slave stays suspect before it is evicted as dead", func(s *Settings) *Duration { return &s.Health.Grace }),
+}
+
+//env name of the env var overriding a setting
+func env(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+//flagValue flag.Value of a setting, it only records the value, settings are applied in precedence order
+type flagValue struct {
+	st    *setting
+	value string
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(v string) error {
+	f.value = v
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.st.boolean
+}
+
+//Parse read the settings of the command line, it exits on an invalid setting, and after
+//printing the effective settings with -print-config
+func Parse(log *logger.XLogger) *Settings {
+	s, printConfig, err := load(flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatal("%s", err)
+	}
+	if printConfig {
+		out, err := s.Print()
+		if err != nil {
+			log.Fatal("Print config: %s", err)
 		}
-		addrIP = splitAddr[0]
-		port := splitAddr[1]
-		if _, err := strconv.Atoi(port); err != nil {
-			log.Fatal("Server port format error")
+		fmt.Print(out)
+		os.Exit(0)
+	}
+	return s
+}
+
+func load(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Settings, bool, error) {
+	s := Defaults()
+
+	configFile := fs.String("config", "", "yaml or json config file, also read from "+env("config"))
+	printConfig := fs.Bool("print-config", false, "print the effective settings and exit")
+	values := make(map[string]*flagValue, len(settings))
+	for _, st := range settings {
+		v := &flagValue{st: st, value: st.get(s)}
+		values[st.name] = v
+		fs.Var(v, st.name, fmt.Sprintf("%s, also read from %s", st.usage, env(st.name)))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv(env("config"))
+	}
+	if path != "" {
+		if err := s.readFile(path); err != nil {
+			return nil, false, fmt.Errorf("config file %s: %s", path, err)
 		}
-		addrPort = port
 	}
 
-	if addrIP == "" {
-		envIP := os.Getenv("GONFIG_HOST_IP")
-		if envIP != "" {
-			addrIP = envIP
-		} else {
-			a, err := ipAddr.ParseIP(*addr)
-			if err != nil {
-				log.Fatal("Server address: %s", err)
+	for _, st := range settings {
+		if v, ok := lookupEnv(env(st.name)); ok {
+			if err := st.set(s, v); err != nil {
+				return nil, false, fmt.Errorf("env %s: %s", env(st.name), err)
 			}
-			addrIP = a
 		}
 	}
-	c.Addr = fmt.Sprintf("%s:%s", addrIP, addrPort)
 
-	if serverRole == types.RoleSlave {
-		splitAddr := strings.Split(*master, ":")
-		if len(splitAddr) != 2 {
-			log.Fatal("Master address format error")
-		}
-		ip := net.ParseIP(splitAddr[0])
-		if ip == nil {
-			log.Fatal("Master ip format error")
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		v, ok := values[f.Name]
+		if !ok || err != nil {
+			return
 		}
-		port := splitAddr[1]
-		if _, err := strconv.Atoi(port); err != nil {
-			log.Fatal("Master port format error")
+		if e := v.st.set(s, v.value); e != nil {
+			err = fmt.Errorf("flag -%s: %s", f.Name, e)
 		}
-		c.MasterAddr = *master
+	})
+	if err != nil {
+		return nil, false, err
 	}
 
-	return c
+	if _, err := s.ServerCfg(); err != nil {
+		return nil, false, err
+	}
+	return s, *printConfig, nil
+}
+
+//readFile read settings from a yaml file, or a json one by the .json extension, unknown keys are rejected
+func (s *Settings) readFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		return dec.Decode(s)
+	}
+	return yaml.UnmarshalStrict(b, s)
+}
+
+//Print effective settings as yaml, the password and the token are masked
+func (s *Settings) Print() (string, error) {
+	c := *s
+	if c.Auth.Password != "" {
+		c.Auth.Password = "******"
+	}
+	if c.Auth.Token != "" {
+		c.Auth.Token = "******"
+	}
+	b, err := yaml.Marshal(&c)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+//ServerCfg server config of the settings
+func (s *Settings) ServerCfg() (*types.ServerCfg, error) {
+	c := &types.ServerCfg{
+		Mode:         types.Mode(strings.ToLower(s.Mode)),
+		Role:         types.Role(strings.Title(strings.ToLower(s.Role))),
+		RPCAddr:      s.RPCAddr,
+		Relay:        s.Relay,
+		Password:     s.Auth.Password,
+		Token:        s.Auth.Token,
+		ReadTimeout:  time.Duration(s.Timeouts.Read),
+		WriteTimeout: time.Duration(s.Timeouts.Write),
+		MaxBodyBytes: s.HTTP.MaxBody,
+		CORSOrigins:  s.HTTP.CORSOrigins,
+		Health: &types.HealthCfg{
+			Interval:         time.Duration(s.Health.Interval),
+			Timeout:          time.Duration(s.Health.Timeout),
+			FailureThreshold: s.Health.Failures,
+			Grace:            time.Duration(s.Health.Grace),
+		},
+	}
+	if c.Mode == types.ModeRaft {
+		//the leader acts as master, the role setting is ignored
+		c.Role = types.RoleMaster
+		c.Peers = s.Peers
+	}
+	if c.Role == types.RoleSlave {
+		c.MasterAddr = s.Master
+	}
+
+	a, err := ipAddr.Advertise(s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("server address: %s", err)
+	}
+	c.Addr = a
+
+	if s.DataDir == "" {
+		return nil, errors.New("data dir can't be empty")
+	}
+	if _, err := s.Level(); err != nil {
+		return nil, err
+	}
+	if (s.TLS.Cert == "") != (s.TLS.Key == "") {
+		return nil, errors.New("tls cert and key must be set together")
+	}
+	if s.Timeouts.Shutdown <= 0 {
+		return nil, errors.New("shutdown timeout must be positive")
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//Level arpc log level of the settings
+func (s *Settings) Level() (int, error) {
+	switch strings.ToLower(s.LogLevel) {
+	case "debug":
+		return alog.LevelDebug, nil
+	case "info":
+		return alog.LevelInfo, nil
+	case "warn":
+		return alog.LevelWarn, nil
+	case "error":
+		return alog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %s", s.LogLevel)
+}
+
+//Certs certificate files of the settings reloaded when they change, nil if tls is off
+func (s *Settings) Certs() (*certs.Reloader, error) {
+	if s.TLS.Cert == "" {
+		return nil, nil
+	}
+	return certs.New(s.TLS.Cert, s.TLS.Key, s.TLS.CA)
 }
@@ -0,0 +1,79 @@
+package cmdflag
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gonfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	yamlFile := filepath.Join(dir, "gonfig.yaml")
+	_ = ioutil.WriteFile(yamlFile, []byte("addr: 127.0.0.1:9019\ndata_dir: /var/gonfig\nhealth:\n  interval: 20s\n  failures: 4\n"), 0644)
+	jsonFile := filepath.Join(dir, "gonfig.json")
+	_ = ioutil.WriteFile(jsonFile, []byte(`{"addr": "127.0.0.1:9019", "timeouts": {"read": "3s"}}`), 0644)
+	badFile := filepath.Join(dir, "bad.yaml")
+	_ = ioutil.WriteFile(badFile, []byte("adr: 127.0.0.1:9019\n"), 0644)
+
+	cases := []struct {
+		args  []string
+		env   map[string]string
+		ok    bool
+		check func(s *Settings) bool
+	}{
+		{[]string{"-addr", "127.0.0.1:9019"}, nil, true, func(s *Settings) bool {
+			return s.DataDir == "./db" && s.Health.Interval == Duration(10*time.Second)
+		}},
+		{[]string{"-config", yamlFile}, nil, true, func(s *Settings) bool {
+			return s.DataDir == "/var/gonfig" && s.Health.Interval == Duration(20*time.Second) && s.Health.Failures == 4
+		}},
+		{nil, map[string]string{"GONFIG_CONFIG": jsonFile}, true, func(s *Settings) bool {
+			return s.Timeouts.Read == Duration(3*time.Second)
+		}},
+		{[]string{"-config", yamlFile}, map[string]string{"GONFIG_HEALTH_INTERVAL": "30s", "GONFIG_DATA_DIR": "/data"}, true, func(s *Settings) bool {
+			return s.DataDir == "/data" && s.Health.Interval == Duration(30*time.Second) && s.Health.Failures == 4
+		}},
+		{[]string{"-config", yamlFile, "-data-dir", "/flag"}, map[string]string{"GONFIG_DATA_DIR": "/data"}, true, func(s *Settings) bool {
+			return s.DataDir == "/flag"
+		}},
+		{[]string{"-addr", "127.0.0.1:8888", "-role", "slave", "-master", "127.0.0.1:9019", "-relay"}, nil, true, func(s *Settings) bool {
+			return s.Relay && s.Role == "slave"
+		}},
+		{[]string{"-addr", "127.0.0.1:9019"}, map[string]string{"GONFIG_TOKEN": "secret"}, true, func(s *Settings) bool {
+			return s.Auth.Token == "secret"
+		}},
+		{[]string{"-cors-origins", "https://a.example,https://b.example"}, map[string]string{"GONFIG_MAX_BODY": "1024"}, true, func(s *Settings) bool {
+			return s.HTTP.MaxBody == 1024 && len(s.HTTP.CORSOrigins) == 2 && s.HTTP.CORSOrigins[1] == "https://b.example"
+		}},
+		{[]string{"-config", badFile}, nil, false, nil},
+		{[]string{"-addr", "127.0.0.1:9019", "-role", "slave"}, nil, false, nil},
+		{[]string{"-addr", "127.0.0.1:9019"}, map[string]string{"GONFIG_HEALTH_TIMEOUT": "ten"}, false, nil},
+		{[]string{"-addr", "127.0.0.1:9019", "-tls-cert", "cert.pem"}, nil, false, nil},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("Load_%d", i), func(t *testing.T) {
+			fs := flag.NewFlagSet("gonfig", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			lookup := func(k string) (string, bool) {
+				v, ok := c.env[k]
+				return v, ok
+			}
+			s, _, err := load(fs, c.args, lookup)
+			if (err == nil) != c.ok {
+				t.FailNow()
+			}
+			if c.ok && !c.check(s) {
+				t.FailNow()
+			}
+		})
+	}
+}
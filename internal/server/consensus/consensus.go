@@ -0,0 +1,404 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/peer"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
+	"github.com/satori/go.uuid"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//State raft node state
+type State int
+
+const (
+	//Follower follows the leader's heartbeats
+	Follower State = iota
+	//Candidate asks peers for votes
+	Candidate
+	//Leader acts as master
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Leader:
+		return "Leader"
+	case Candidate:
+		return "Candidate"
+	default:
+		return "Follower"
+	}
+}
+
+const (
+	//HeartbeatInterval interval of leader heartbeats
+	HeartbeatInterval = 500 * time.Millisecond
+	//ElectionTimeout min duration without heartbeat before a follower stands for election,
+	//the actual timeout is randomized up to twice of it
+	ElectionTimeout = 1500 * time.Millisecond
+	tick            = 100 * time.Millisecond
+)
+
+//Config raft node config
+type Config struct {
+	ID   uuid.UUID
+	Addr string
+	//RPCAddr advertised rpc address reported to the leader
+	RPCAddr string
+	Peers   []string
+	Store   store.Store
+	Logger  logger.Logger
+	//Client client between nodes, plain http is used if nil
+	Client *peer.Client
+	//Clients count of connected rpc clients reported to the leader
+	Clients func() int
+	//Status marked ready once the node is leader or has caught up with the leader
+	Status *types.Status
+	//OnLeader called after the node has been elected
+	OnLeader func(term uint64)
+	//OnFollower called after the node has stepped down from leader
+	OnFollower func(term uint64)
+	//OnHeartbeat called with each follower's heartbeat response while the node is leader
+	OnHeartbeat func(addr string, resp *types.AppendResp)
+}
+
+//Node raft node electing a leader among peers. it only elects, the leader syncs its configs
+//to the peers through the sync path and a write is committed once a majority applied it,
+//heartbeats only carry the election state
+type Node struct {
+	cfg      *Config
+	mux      sync.Mutex
+	state    State
+	term     uint64
+	votedFor string
+	leader   string
+	contact  time.Time
+	timeout  time.Duration
+	client   *http.Client
+	peer     *peer.Client
+}
+
+//New construct raft node, its term and vote are restored from store
+func New(cfg *Config) *Node {
+	pc := cfg.Client
+	if pc == nil {
+		pc = peer.New(nil, "")
+	}
+	n := &Node{
+		cfg:     cfg,
+		contact: time.Now(),
+		client:  &http.Client{Timeout: HeartbeatInterval, Transport: pc.RoundTripper()},
+		peer:    pc,
+	}
+	if v, err := cfg.Store.Get([]byte(types.RaftTermKey)); err == nil {
+		n.term, _ = strconv.ParseUint(string(v), 10, 64)
+	}
+	if v, err := cfg.Store.Get([]byte(types.RaftVoteKey)); err == nil {
+		n.votedFor = string(v)
+	}
+	n.resetTimeout()
+	return n
+}
+
+//Run drive elections and heartbeats until stop is closed
+func (n *Node) Run(stop <-chan struct{}) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	var lastBeat time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		n.mux.Lock()
+		state := n.state
+		expired := time.Since(n.contact) > n.timeout
+		n.mux.Unlock()
+
+		if state == Leader {
+			if time.Since(lastBeat) >= HeartbeatInterval {
+				lastBeat = time.Now()
+				n.heartbeat()
+			}
+		} else if expired {
+			n.elect()
+		}
+	}
+}
+
+//Term current term
+func (n *Node) Term() uint64 {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.term
+}
+
+//IsLeader whether the node is leader
+func (n *Node) IsLeader() bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.state == Leader
+}
+
+//Leader address of the known leader, empty if unknown
+func (n *Node) Leader() string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.leader
+}
+
+//Peers addresses of the other nodes
+func (n *Node) Peers() []string {
+	return n.cfg.Peers
+}
+
+//State current state
+func (n *Node) State() State {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	return n.state
+}
+
+//Vote handle a candidate's vote request
+func (n *Node) Vote(req *types.VoteReq) *types.VoteResp {
+	revision, lastTerm := n.log()
+
+	n.mux.Lock()
+	stepDown := false
+	if req.Term > n.term {
+		stepDown = n.becomeFollower(req.Term, "")
+	}
+	resp := &types.VoteResp{Term: n.term}
+	upToDate := req.LastTerm > lastTerm || (req.LastTerm == lastTerm && req.Revision >= revision)
+	if req.Term == n.term && (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		n.votedFor = req.Candidate
+		n.contact = time.Now()
+		n.persist()
+		resp.Granted = true
+	}
+	term := n.term
+	n.mux.Unlock()
+
+	if stepDown && n.cfg.OnFollower != nil {
+		n.cfg.OnFollower(term)
+	}
+	return resp
+}
+
+//Append handle a leader's heartbeat
+func (n *Node) Append(req *types.AppendReq) *types.AppendResp {
+	n.mux.Lock()
+	if req.Term < n.term {
+		resp := &types.AppendResp{Term: n.term}
+		n.mux.Unlock()
+		return resp
+	}
+	stepDown := n.becomeFollower(req.Term, req.Leader)
+	n.contact = time.Now()
+	term := n.term
+	n.mux.Unlock()
+
+	if stepDown && n.cfg.OnFollower != nil {
+		n.cfg.OnFollower(term)
+	}
+
+	revision, lastTerm := n.log()
+	if revision == req.Revision && lastTerm == req.LastTerm && n.cfg.Status != nil {
+		n.cfg.Status.SetReady(true)
+	}
+	resp := &types.AppendResp{
+		Term:     term,
+		Success:  true,
+		ID:       n.cfg.ID,
+		Revision: revision,
+		LastTerm: lastTerm,
+		RPCAddr:  n.cfg.RPCAddr,
+	}
+	if n.cfg.Clients != nil {
+		resp.Clients = n.cfg.Clients()
+	}
+	resp.Credentials, _ = credential.Version(n.cfg.Store)
+	return resp
+}
+
+//becomeFollower must be called with mux held, it returns whether the node was leader
+func (n *Node) becomeFollower(term uint64, leader string) bool {
+	wasLeader := n.state == Leader
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persist()
+	}
+	if n.state != Follower {
+		n.cfg.Logger.Info("Raft term:[%s] %s -> %s", color.Green(term), color.Green(n.state), color.Green(Follower))
+	}
+	n.state = Follower
+	if leader != "" && leader != n.leader {
+		n.cfg.Logger.Info("Raft term:[%s] leader:[%s]", color.Green(term), color.Green(leader))
+		n.leader = leader
+	}
+	n.resetTimeout()
+	return wasLeader
+}
+
+func (n *Node) elect() {
+	revision, lastTerm := n.log()
+
+	n.mux.Lock()
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.cfg.Addr
+	n.leader = ""
+	n.contact = time.Now()
+	n.resetTimeout()
+	n.persist()
+	term := n.term
+	n.mux.Unlock()
+
+	n.cfg.Logger.Info("Raft term:[%s] stand for election", color.Green(term))
+
+	req := &types.VoteReq{
+		Term:      term,
+		Candidate: n.cfg.Addr,
+		Revision:  revision,
+		LastTerm:  lastTerm,
+	}
+	votes := 1
+	vmux := sync.Mutex{}
+	g := sync.WaitGroup{}
+	g.Add(len(n.cfg.Peers))
+	for _, peer := range n.cfg.Peers {
+		go func(addr string) {
+			defer g.Done()
+			var resp types.VoteResp
+			if err := n.call(addr, "/raft/vote", req, &resp); err != nil {
+				return
+			}
+			if resp.Term > term {
+				n.observe(resp.Term)
+				return
+			}
+			if resp.Granted {
+				vmux.Lock()
+				votes++
+				vmux.Unlock()
+			}
+		}(peer)
+	}
+	g.Wait()
+
+	n.mux.Lock()
+	if n.state != Candidate || n.term != term || votes < n.quorum() {
+		n.mux.Unlock()
+		return
+	}
+	n.state = Leader
+	n.leader = n.cfg.Addr
+	n.mux.Unlock()
+
+	n.cfg.Logger.Info("Raft term:[%s] elected leader with [%s] votes", color.Green(term), color.Green(votes))
+	if n.cfg.Status != nil {
+		n.cfg.Status.SetReady(true)
+	}
+	if n.cfg.OnLeader != nil {
+		n.cfg.OnLeader(term)
+	}
+	n.heartbeat()
+}
+
+func (n *Node) heartbeat() {
+	revision, lastTerm := n.log()
+
+	n.mux.Lock()
+	req := &types.AppendReq{Term: n.term, Leader: n.cfg.Addr, Revision: revision, LastTerm: lastTerm}
+	n.mux.Unlock()
+
+	for _, peer := range n.cfg.Peers {
+		go func(addr string) {
+			var resp types.AppendResp
+			if err := n.call(addr, "/raft/append", req, &resp); err != nil {
+				return
+			}
+			if resp.Term > req.Term {
+				n.observe(resp.Term)
+				return
+			}
+			if resp.Success && n.cfg.OnHeartbeat != nil && n.IsLeader() {
+				n.cfg.OnHeartbeat(addr, &resp)
+			}
+		}(peer)
+	}
+}
+
+//observe step down after seeing a higher term
+func (n *Node) observe(term uint64) {
+	n.mux.Lock()
+	if term <= n.term {
+		n.mux.Unlock()
+		return
+	}
+	stepDown := n.becomeFollower(term, "")
+	n.leader = ""
+	n.mux.Unlock()
+
+	if stepDown && n.cfg.OnFollower != nil {
+		n.cfg.OnFollower(term)
+	}
+}
+
+func (n *Node) call(addr string, path string, req interface{}, resp interface{}) error {
+	jsonBytes, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := n.client.Post(n.peer.URL(addr, path), "application:json", strings.NewReader(string(jsonBytes)))
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("raft %s failure: %s", path, r.Status)
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+//log global revision and its term of the node's config log
+func (n *Node) log() (uint64, uint64) {
+	revision, _ := history.Current(n.cfg.Store)
+	lastTerm, _ := history.LastTerm(n.cfg.Store)
+	return revision, lastTerm
+}
+
+//persist must be called with mux held
+func (n *Node) persist() {
+	err := n.cfg.Store.Put([]byte(types.RaftTermKey), []byte(strconv.FormatUint(n.term, 10)))
+	if err == nil {
+		err = n.cfg.Store.Put([]byte(types.RaftVoteKey), []byte(n.votedFor))
+	}
+	if err != nil {
+		n.cfg.Logger.Error("Raft persist term: %s", err)
+	}
+}
+
+func (n *Node) quorum() int {
+	return (len(n.cfg.Peers)+1)/2 + 1
+}
+
+func (n *Node) resetTimeout() {
+	n.contact = time.Now()
+	n.timeout = ElectionTimeout + time.Duration(rand.Int63n(int64(ElectionTimeout)))
+}
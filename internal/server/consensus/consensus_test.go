@@ -0,0 +1,73 @@
+package consensus
+
+import (
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"testing"
+)
+
+func newNode(st store.Store) *Node {
+	return New(&Config{
+		Addr:   "127.0.0.1:9019",
+		Peers:  []string{"127.0.0.1:9029", "127.0.0.1:9039"},
+		Store:  st,
+		Logger: &logger.XLogger{},
+	})
+}
+
+func TestVote(t *testing.T) {
+	st := store.NewMapStore()
+	_, _ = history.Commit(st, "config/app/#dev", "version: 1", "tester", nil)
+	_, _ = history.Commit(st, "config/app/#dev", "version: 2", "tester", nil)
+	n := newNode(st)
+
+	cases := []struct {
+		req     types.VoteReq
+		granted bool
+	}{
+		{types.VoteReq{Term: 1, Candidate: "a", Revision: 1}, false},
+		{types.VoteReq{Term: 1, Candidate: "a", Revision: 2}, true},
+		{types.VoteReq{Term: 1, Candidate: "b", Revision: 2}, false},
+		{types.VoteReq{Term: 1, Candidate: "a", Revision: 3}, true},
+		{types.VoteReq{Term: 0, Candidate: "c", Revision: 3}, false},
+		{types.VoteReq{Term: 2, Candidate: "c", Revision: 1, LastTerm: 1}, true},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("Vote_%d", i), func(t *testing.T) {
+			resp := n.Vote(&c.req)
+			if resp.Granted != c.granted {
+				t.FailNow()
+			}
+		})
+	}
+
+	t.Run("Vote_Persist", func(t *testing.T) {
+		n2 := newNode(st)
+		if n2.Term() != 2 || n2.votedFor != "c" {
+			t.FailNow()
+		}
+	})
+}
+
+func TestAppend(t *testing.T) {
+	st := store.NewMapStore()
+	n := newNode(st)
+	n.state = Leader
+
+	t.Run("Append_Stale", func(t *testing.T) {
+		n.term = 3
+		resp := n.Append(&types.AppendReq{Term: 2, Leader: "127.0.0.1:9029"})
+		if resp.Success || resp.Term != 3 || !n.IsLeader() {
+			t.FailNow()
+		}
+	})
+	t.Run("Append_StepDown", func(t *testing.T) {
+		resp := n.Append(&types.AppendReq{Term: 4, Leader: "127.0.0.1:9029"})
+		if !resp.Success || n.IsLeader() || n.Leader() != "127.0.0.1:9029" || n.Term() != 4 {
+			t.FailNow()
+		}
+	})
+}
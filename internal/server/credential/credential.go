@@ -0,0 +1,378 @@
+package credential
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrInvalidApp application name is not made of letters, digits, '_' and '-'
+	ErrInvalidApp = errors.New("invalid application name")
+	//ErrExists application already has a credential
+	ErrExists = errors.New("credential already exists")
+	//ErrNotFound application has no credential
+	ErrNotFound = errors.New("credential not found")
+	//ErrInvalidGrant grant has a malformed name pattern
+	ErrInvalidGrant = errors.New("invalid grant")
+	//ErrInvalidRole role is none of reader, writer, admin and replica
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+var appPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+//Key credential key an application authenticates with, made of its name and secret
+func Key(app string, secret string) string {
+	return app + "." + secret
+}
+
+//ParseKey split credential key into application name and secret
+func ParseKey(key string) (string, string, bool) {
+	idx := strings.LastIndex(key, ".")
+	if idx <= 0 || idx == len(key)-1 {
+		return "", "", false
+	}
+	return key[:idx], key[idx+1:], true
+}
+
+//Hash hex encoded sha256 of secret
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+//Create credential of app with roles granted access to grants and bump the version of the set,
+//the returned key is not stored
+func Create(st store.Store, app string, grants []*types.Grant, roles []types.Access) (string, error) {
+	if !appPattern.MatchString(app) {
+		return "", ErrInvalidApp
+	}
+	if err := validate(grants, roles); err != nil {
+		return "", err
+	}
+
+	// changes of the credential set and its version are serialized
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	_, err := st.Get([]byte(fmt.Sprintf(types.CredentialFormat, app)))
+	if err == nil {
+		return "", ErrExists
+	}
+	if err != store.ErrNotFound {
+		return "", err
+	}
+
+	buf := make([]byte, 24)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+
+	c := &types.Credential{App: app, Hash: Hash(secret), Created: time.Now().Unix(), Grants: grants, Roles: roles}
+	err = put(st, c)
+	if err != nil {
+		return "", err
+	}
+	return Key(app, secret), bump(st)
+}
+
+//Grant replace the grants of app's credential, and its roles unless roles is nil, and bump
+//the version of the set
+func Grant(st store.Store, app string, grants []*types.Grant, roles []types.Access) error {
+	if err := validate(grants, roles); err != nil {
+		return err
+	}
+
+	// changes of the credential set and its version are serialized
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	c, err := Get(st, app)
+	if err != nil {
+		return err
+	}
+	c.Grants = grants
+	if roles != nil {
+		c.Roles = roles
+	}
+	err = put(st, c)
+	if err != nil {
+		return err
+	}
+	return bump(st)
+}
+
+func validate(grants []*types.Grant, roles []types.Access) error {
+	for _, g := range grants {
+		if g == nil || g.Name == "" {
+			return ErrInvalidGrant
+		}
+		if _, err := path.Match(g.Name, ""); err != nil {
+			return ErrInvalidGrant
+		}
+		if g.Access != "" && g.Access != types.AccessReader && g.Access != types.AccessWriter {
+			return ErrInvalidGrant
+		}
+	}
+	for _, r := range roles {
+		switch r {
+		case types.AccessReader, types.AccessWriter, types.AccessAdmin, types.AccessReplica:
+		default:
+			return ErrInvalidRole
+		}
+	}
+	return nil
+}
+
+func put(st store.Store, c *types.Credential) error {
+	cBytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return st.Put([]byte(fmt.Sprintf(types.CredentialFormat, c.App)), cBytes)
+}
+
+//Get credential of app
+func Get(st store.Store, app string) (*types.Credential, error) {
+	v, err := st.Get([]byte(fmt.Sprintf(types.CredentialFormat, app)))
+	if err == store.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c types.Credential
+	err = json.Unmarshal(v, &c)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+//Allowed whether app may read the config of name and tags, an empty app stands for
+//the shared password or the cluster token which may access any config, so does an admin
+func Allowed(st store.Store, app string, name string, tags []string) bool {
+	return allowed(st, app, name, tags, false)
+}
+
+//Writable whether app may write the config of name and tags, which takes a grant of
+//types.AccessWriter, an empty app and an admin may write any config
+func Writable(st store.Store, app string, name string, tags []string) bool {
+	return allowed(st, app, name, tags, true)
+}
+
+func allowed(st store.Store, app string, name string, tags []string, write bool) bool {
+	if app == "" {
+		return true
+	}
+	c, err := Get(st, app)
+	if err != nil {
+		return false
+	}
+	if hasRole(c, types.AccessAdmin) {
+		return true
+	}
+	for _, g := range c.Grants {
+		if write && g.Access != types.AccessWriter {
+			continue
+		}
+		if ok, _ := path.Match(g.Name, name); ok && hasTags(tags, g.Tags) {
+			return true
+		}
+	}
+	return false
+}
+
+//HasRole whether app's credential has role on the http api. an admin has every role, a writer
+//reads too and a credential without roles is a reader
+func HasRole(st store.Store, app string, role types.Access) bool {
+	c, err := Get(st, app)
+	if err != nil {
+		return false
+	}
+	return hasRole(c, role)
+}
+
+func hasRole(c *types.Credential, role types.Access) bool {
+	if len(c.Roles) == 0 {
+		return role == types.AccessReader
+	}
+	for _, r := range c.Roles {
+		if r == role || r == types.AccessAdmin || (r == types.AccessWriter && role == types.AccessReader) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTags(tags []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//Revoke delete the credential of app and bump the version of the set
+func Revoke(st store.Store, app string) error {
+	// changes of the credential set and its version are serialized
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	key := []byte(fmt.Sprintf(types.CredentialFormat, app))
+	_, err := st.Get(key)
+	if err == store.ErrNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	err = st.Delete(key)
+	if err != nil {
+		return err
+	}
+	return bump(st)
+}
+
+//Version version of the credential set, zero if it has never changed
+func Version(st store.Store) (uint64, error) {
+	v, err := st.Get([]byte(types.CredentialsVersionKey))
+	if err == store.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(v), 10, 64)
+}
+
+func bump(st store.Store) error {
+	version, err := Version(st)
+	if err != nil {
+		return err
+	}
+	return st.Put([]byte(types.CredentialsVersionKey), []byte(strconv.FormatUint(version+1, 10)))
+}
+
+//List credentials ordered by application name
+func List(st store.Store) ([]*types.Credential, error) {
+	pairs, err := st.Items("credential/")
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*types.Credential, 0, len(pairs))
+	for _, kv := range pairs {
+		var c types.Credential
+		err = json.Unmarshal(kv.Value, &c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].App < out[j].App
+	})
+	return out, nil
+}
+
+//Snapshot sync request carrying the full credential set and its version
+func Snapshot(st store.Store) (*types.SyncCredentialsReq, error) {
+	version, err := Version(st)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := List(st)
+	if err != nil {
+		return nil, err
+	}
+	return &types.SyncCredentialsReq{Version: version, Credentials: credentials}, nil
+}
+
+//Apply replace the slave's credential set with master's, it returns the
+//applications whose credential was revoked, replaced or granted other configs
+func Apply(st store.Store, req *types.SyncCredentialsReq) ([]string, error) {
+	// changes of the credential set and its version are serialized
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	local, err := List(st)
+	if err != nil {
+		return nil, err
+	}
+	remote := make(map[string]*types.Credential, len(req.Credentials))
+	for _, c := range req.Credentials {
+		remote[c.App] = c
+	}
+
+	revoked := make([]string, 0)
+	for _, c := range local {
+		r, ok := remote[c.App]
+		if ok && r.Hash == c.Hash && reflect.DeepEqual(r.Grants, c.Grants) {
+			continue
+		}
+		revoked = append(revoked, c.App)
+		if !ok {
+			err = st.Delete([]byte(fmt.Sprintf(types.CredentialFormat, c.App)))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, c := range req.Credentials {
+		err = put(st, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return revoked, st.Put([]byte(types.CredentialsVersionKey), []byte(strconv.FormatUint(req.Version, 10)))
+}
+
+//Verify application authenticated by key, ok is false if the key is unknown
+func Verify(st store.Store, key string) (string, bool) {
+	app, secret, ok := ParseKey(key)
+	if !ok {
+		return "", false
+	}
+	c, err := Get(st, app)
+	if err != nil {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(Hash(secret)), []byte(c.Hash)) != 1 {
+		return "", false
+	}
+	return app, true
+}
+
+//Empty whether no application has a credential
+func Empty(st store.Store) bool {
+	pairs, err := st.Items("credential/")
+	return err == nil && len(pairs) == 0
+}
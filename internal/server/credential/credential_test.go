@@ -0,0 +1,169 @@
+package credential
+
+import (
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"testing"
+)
+
+func TestCredential(t *testing.T) {
+	master := store.NewMapStore()
+	slave := store.NewMapStore()
+
+	var key string
+	t.Run("Credential_Create", func(t *testing.T) {
+		var err error
+		if !Empty(master) {
+			t.FailNow()
+		}
+		key, err = Create(master, "billing", []*types.Grant{{Name: "billing-*", Tags: []string{"prod"}}}, nil)
+		if err != nil {
+			t.FailNow()
+		}
+		if _, err := Create(master, "billing", nil, nil); err != ErrExists {
+			t.FailNow()
+		}
+		if _, err := Create(master, "bad.app", nil, nil); err != ErrInvalidApp {
+			t.FailNow()
+		}
+		if _, err := Create(master, "web", []*types.Grant{{Name: "["}}, nil); err != ErrInvalidGrant {
+			t.FailNow()
+		}
+		if _, err := Create(master, "web", []*types.Grant{{Name: "web", Access: types.AccessAdmin}}, nil); err != ErrInvalidGrant {
+			t.FailNow()
+		}
+		if _, err := Create(master, "web", nil, []types.Access{"root"}); err != ErrInvalidRole {
+			t.FailNow()
+		}
+		version, _ := Version(master)
+		if version != 1 || Empty(master) {
+			t.FailNow()
+		}
+	})
+	t.Run("Credential_Verify", func(t *testing.T) {
+		if app, ok := Verify(master, key); !ok || app != "billing" {
+			t.FailNow()
+		}
+		if _, ok := Verify(master, key+"0"); ok {
+			t.FailNow()
+		}
+		if _, ok := Verify(master, "billing"); ok {
+			t.FailNow()
+		}
+	})
+	t.Run("Credential_Allowed", func(t *testing.T) {
+		tests := []struct {
+			app     string
+			name    string
+			tags    []string
+			allowed bool
+		}{
+			{"billing", "billing-api", []string{"prod", "eu"}, true},
+			{"billing", "billing-api", []string{"dev"}, false},
+			{"billing", "web", []string{"prod"}, false},
+			{"unknown", "billing-api", []string{"prod"}, false},
+			{"", "web", nil, true},
+		}
+		for i, test := range tests {
+			t.Run(fmt.Sprintf("Allowed_%d", i), func(t *testing.T) {
+				if Allowed(master, test.app, test.name, test.tags) != test.allowed {
+					t.FailNow()
+				}
+			})
+		}
+	})
+	t.Run("Credential_Apply", func(t *testing.T) {
+		req, _ := Snapshot(master)
+		revoked, err := Apply(slave, req)
+		if err != nil || len(revoked) != 0 {
+			t.FailNow()
+		}
+		if app, ok := Verify(slave, key); !ok || app != "billing" {
+			t.FailNow()
+		}
+	})
+	t.Run("Credential_Grant", func(t *testing.T) {
+		if err := Grant(master, "billing", []*types.Grant{{Name: "*"}}, nil); err != nil {
+			t.FailNow()
+		}
+		if !Allowed(master, "billing", "web", nil) {
+			t.FailNow()
+		}
+		req, _ := Snapshot(master)
+		changed, err := Apply(slave, req)
+		if err != nil || len(changed) != 1 || changed[0] != "billing" {
+			t.FailNow()
+		}
+		if !Allowed(slave, "billing", "web", nil) {
+			t.FailNow()
+		}
+	})
+	t.Run("Credential_Role", func(t *testing.T) {
+		if !HasRole(master, "billing", types.AccessReader) || HasRole(master, "billing", types.AccessWriter) {
+			t.FailNow()
+		}
+		if err := Grant(master, "billing", []*types.Grant{{Name: "billing-*"}}, []types.Access{types.AccessWriter}); err != nil {
+			t.FailNow()
+		}
+		if !HasRole(master, "billing", types.AccessReader) || !HasRole(master, "billing", types.AccessWriter) || HasRole(master, "billing", types.AccessReplica) {
+			t.FailNow()
+		}
+		if Allowed(master, "billing", "web", nil) {
+			t.FailNow()
+		}
+		if err := Grant(master, "billing", []*types.Grant{{Name: "billing-*"}, {Name: "billing-api", Access: types.AccessWriter}}, nil); err != nil {
+			t.FailNow()
+		}
+		tests := []struct {
+			app      string
+			name     string
+			writable bool
+		}{
+			{"billing", "billing-api", true},
+			{"billing", "billing-web", false},
+			{"billing", "web", false},
+			{"", "web", true},
+		}
+		for i, test := range tests {
+			t.Run(fmt.Sprintf("Writable_%d", i), func(t *testing.T) {
+				if Writable(master, test.app, test.name, nil) != test.writable || !Allowed(master, "billing", "billing-web", nil) {
+					t.FailNow()
+				}
+			})
+		}
+		if _, err := Create(master, "ops", nil, []types.Access{types.AccessAdmin}); err != nil {
+			t.FailNow()
+		}
+		if !HasRole(master, "ops", types.AccessReplica) || !Allowed(master, "ops", "web", nil) || !Writable(master, "ops", "web", nil) {
+			t.FailNow()
+		}
+		if err := Revoke(master, "ops"); err != nil {
+			t.FailNow()
+		}
+		req, _ := Snapshot(master)
+		if _, err := Apply(slave, req); err != nil || !HasRole(slave, "billing", types.AccessWriter) {
+			t.FailNow()
+		}
+	})
+	t.Run("Credential_Revoke", func(t *testing.T) {
+		if err := Revoke(master, "billing"); err != nil {
+			t.FailNow()
+		}
+		if err := Revoke(master, "billing"); err != ErrNotFound {
+			t.FailNow()
+		}
+		req, _ := Snapshot(master)
+		revoked, err := Apply(slave, req)
+		if err != nil || len(revoked) != 1 || revoked[0] != "billing" {
+			t.FailNow()
+		}
+		if _, ok := Verify(slave, key); ok {
+			t.FailNow()
+		}
+		version, _ := Version(slave)
+		if version != 7 {
+			t.FailNow()
+		}
+	})
+}
@@ -3,6 +3,13 @@ package event
 const (
 	SyncConfig = "SyncConfig"
 	PubConfig  = "PubConfig"
+	//SlaveHealth a slave's health state changed, the body carries the *types.HealthEvent as "event"
+	SlaveHealth = "SlaveHealth"
+	//SyncCredentials push the credential set to slaves holding another version of it
+	SyncCredentials = "SyncCredentials"
+	//RevokeCredential close the rpc connections authenticated by the credential of "app",
+	//they have to authenticate again under its current grants
+	RevokeCredential = "RevokeCredential"
 )
 
 type Event struct {
@@ -16,6 +23,16 @@ func (t Trigger) Emit(event *Event) {
 	t <- event
 }
 
+//TryEmit emit event unless the trigger is full
+func (t Trigger) TryEmit(event *Event) bool {
+	select {
+	case t <- event:
+		return true
+	default:
+		return false
+	}
+}
+
 func (t Trigger) C() chan *Event {
 	return t
 }
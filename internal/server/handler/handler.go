@@ -1,15 +1,31 @@
 package handler
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/audit"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
 	"github.com/Jarnpher553/gonfig/internal/server/event"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/merkle"
+	"github.com/Jarnpher553/gonfig/internal/server/middleware"
+	"github.com/Jarnpher553/gonfig/internal/server/peer"
+	"github.com/Jarnpher553/gonfig/internal/server/replication"
+	"github.com/Jarnpher553/gonfig/internal/server/route"
 	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
 	"github.com/Jarnpher553/gonfig/internal/util/color"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
 type HandlerFunc func(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request)
@@ -17,74 +33,70 @@ type HandlerFunc func(s *types.ServiceCtx, method string) func(w http.ResponseWr
 // RegisterSlaveHandler 注册从节点
 func RegisterSlaveHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
-			return
-		}
-
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		var meta types.SlaveMetaReq
+		if !decode(w, r, &meta) {
 			return
 		}
 
-		var meta types.SlaveMetaReq
-		err = json.Unmarshal(body, &meta)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		// a slave whose subtree holds this node or one of its upstreams would replicate in a loop
+		if s.Chain.Contains(append([]string{meta.Addr}, meta.Descendants...)...) {
+			s.Logger.Info("Slave id:[%s] addr:[%s] rejected, replication loop through:[%s]", color.Green(meta.ID), color.Green(meta.Addr), color.Green(s.Chain.Addrs()))
+			fail(w, r, http.StatusConflict, "replication loop through "+strings.Join(s.Chain.Addrs(), ","))
 			return
 		}
 
 		slave := types.ServerMetadata{
-			ID:    meta.ID,
-			Role:  types.Role(meta.Role),
-			RAddr: meta.Addr,
+			ID:          meta.ID,
+			Role:        types.Role(meta.Role),
+			RAddr:       meta.Addr,
+			RPCAddr:     meta.RPCAddr,
+			Revision:    meta.Revision,
+			Credentials: meta.Credentials,
 		}
 
-		err = s.Store.Put([]byte(fmt.Sprintf(types.SlaveFormat, slave.ID)), []byte(fmt.Sprintf("%s", slave.RAddr)))
+		err := s.Store.Put([]byte(fmt.Sprintf(types.SlaveFormat, slave.ID)), []byte(fmt.Sprintf("%s", slave.RAddr)))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
 		s.Mux.Lock()
 		defer s.Mux.Unlock()
-		*s.Slaves = append(*s.Slaves, &slave)
+		// a slave re-registering after losing the master replaces its old entry
+		replaced := false
+		for idx, sl := range *s.Slaves {
+			if sl.ID == slave.ID {
+				(*s.Slaves)[idx] = &slave
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			*s.Slaves = append(*s.Slaves, &slave)
+		}
 
 		s.Logger.Info("Slave id:[%s] addr:[%s] online", color.Green(slave.ID), color.Green(slave.RAddr))
+		if version, err := credential.Version(s.Store); err == nil && version != slave.Credentials {
+			s.Trigger.TryEmit(&event.Event{Type: event.SyncCredentials})
+		}
+
+		respBytes, err := json.Marshal(&types.RegisterResp{Chain: s.Chain.Addrs()})
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
 
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(http.StatusText(http.StatusOK)))
+		w.Write(respBytes)
 	}
 }
 
 // UnregisterSlaveHandler 注销从节点
 func UnregisterSlaveHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
-			return
-		}
-
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
-			return
-		}
-
 		var meta types.SlaveMetaReq
-		err = json.Unmarshal(body, &meta)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		if !decode(w, r, &meta) {
 			return
 		}
 
@@ -101,8 +113,7 @@ func UnregisterSlaveHandler(s *types.ServiceCtx, method string) func(w http.Resp
 		if index >= 0 {
 			err := s.Store.Delete([]byte(fmt.Sprintf(types.SlaveFormat, meta.ID)))
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+				internalError(s, w, r, err)
 				return
 			}
 			if index == len(*s.Slaves)-1 {
@@ -119,115 +130,482 @@ func UnregisterSlaveHandler(s *types.ServiceCtx, method string) func(w http.Resp
 	}
 }
 
+// HeartbeatHandler 从节点心跳，主节点不认识的从节点返回404，需重新注册
+func HeartbeatHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var meta types.SlaveMetaReq
+		if !decode(w, r, &meta) {
+			return
+		}
+
+		known := false
+		s.Mux.Lock()
+		for _, sl := range *s.Slaves {
+			if sl.ID == meta.ID {
+				sl.Revision = meta.Revision
+				if meta.RPCAddr != "" {
+					sl.RPCAddr = meta.RPCAddr
+				}
+				sl.Credentials = meta.Credentials
+				known = true
+				break
+			}
+		}
+		s.Mux.Unlock()
+		if known {
+			s.Acks.Notify()
+		}
+		if !known {
+			fail(w, r, http.StatusNotFound, "slave not registered")
+			return
+		}
+
+		current, err := history.Current(s.Store)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+		if version, err := credential.Version(s.Store); err == nil && version != meta.Credentials {
+			s.Trigger.TryEmit(&event.Event{Type: event.SyncCredentials})
+		}
+		respBytes, err := json.Marshal(&types.HeartbeatResp{Revision: current})
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
 // SyncConfigurationHandler 主从同步配置
 func SyncConfigurationHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		var req types.SyncConfigReq
+		if !decode(w, r, &req) {
+			return
+		}
+
+		// in raft mode only the leader of the current term may replicate
+		if s.Consensus != nil && req.Term < s.Consensus.Term() {
+			fail(w, r, http.StatusForbidden, "stale raft term")
+			return
+		}
+
+		status := http.StatusOK
+		changes, err := replication.Apply(s.Store, &req)
+		if err == replication.ErrOutOfOrder {
+			status = http.StatusConflict
+		} else if err != nil {
+			internalError(s, w, r, err)
 			return
 		}
+		for _, c := range changes {
+			log.Printf("Slave config name:[%s] tag:[%s] deleted:[%t] sync success", color.Green(c.Name), color.Green(c.Tag), c.Deleted)
+		}
+		for _, ev := range replication.Events(changes) {
+			s.Trigger.Emit(ev)
+		}
+		// a relay slave fans the changes out to its downstream slaves
+		if s.Slaves != nil && len(changes) != 0 {
+			s.Trigger.Emit(&event.Event{Type: event.SyncConfig})
+		}
 
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
+		applied, term, err := replication.Applied(s.Store)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
+			return
+		}
+		resp := struct {
+			*types.ErrorResp
+			types.SyncConfigResp
+		}{SyncConfigResp: types.SyncConfigResp{Revision: applied, Term: term}}
+		if status == http.StatusConflict {
+			e := errorResp(r, status, replication.ErrOutOfOrder.Error())
+			resp.ErrorResp = &e
+		}
+		respBytes, err := json.Marshal(&resp)
+		if err != nil {
+			internalError(s, w, r, err)
 			return
 		}
 
-		var req types.SyncConfigReq
-		err = json.Unmarshal(body, &req)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(respBytes)
+	}
+}
+
+// ChangesHandler 从节点追赶主节点的增量或全量配置
+func ChangesHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.ChangesReq
+		if !decode(w, r, &c) {
+			return
+		}
+
+		req, err := replication.Delta(s.Store, c.Revision)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
-		for _, c := range req.Datum {
-			err = s.Store.Put([]byte(fmt.Sprintf(types.ConfigFormat, c.Name, strings.Join(c.Tag, "#"))), []byte(c.Body))
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
-				return
-			}
-			log.Printf("Slave config name:[%s] tag:[%s] sync success", color.Green(c.Name), color.Green(c.Tag))
+
+		respBytes, err := json.Marshal(req)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(http.StatusText(http.StatusOK)))
+		w.Write(respBytes)
 	}
 }
 
 // PushConfigHandler 推送配置
 func PushConfigHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		var c types.PushConfigReq
+		if !decode(w, r, &c) {
+			return
+		}
+		if !c.WriteConcern.Valid() {
+			fail(w, r, http.StatusBadRequest, "invalid write concern")
+			return
+		}
+
+		if !permitted(s, w, r, "Push", true, c.Name, c.Tag) {
 			return
 		}
 
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
+		if c.Author == "" {
+			c.Author = remoteAddr(s, r)
+		}
+
+		var expect *types.Precondition
+		if c.ExpectedRevision != nil || c.ExpectedHash != "" {
+			expect = &types.Precondition{Revision: c.ExpectedRevision, Hash: c.ExpectedHash}
+		}
+
+		cfgName := fmt.Sprintf(types.ConfigFormat, c.Name, strings.Join(c.Tag, "#"))
+		rev, err := history.Commit(s.Store, cfgName, c.Body, c.Author, auditEntry(s, r, types.AuditPush, c.Author), expect)
+		if err == history.ErrConflict {
+			conflict(s, w, r, cfgName)
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
-		var c types.PushConfigReq
-		err = json.Unmarshal(body, &c)
+		s.Trigger.Emit(&event.Event{Type: event.PubConfig, Body: map[string]interface{}{"cfgName": cfgName, "cfgMeta": c.Body}})
+		s.Trigger.Emit(&event.Event{Type: event.SyncConfig})
+
+		written(s, w, r, rev, c.WriteConcern)
+	}
+}
+
+// auditEntry 写操作的审计记录，操作者为认证的应用，作者由请求指定。记录与配置在同一次提交中写入，
+// 并随配置变更同步到从节点
+func auditEntry(s *types.ServiceCtx, r *http.Request, op types.AuditOp, author string) *types.AuditEntry {
+	actor, ok := types.App(r.Context())
+	if ok && actor == "" {
+		actor = types.AuditTokenActor
+	}
+	return &types.AuditEntry{Op: op, Actor: actor, Author: author, IP: sourceIP(s, r)}
+}
+
+// errorResp 请求的错误信封，错误码由状态码决定
+func errorResp(r *http.Request, status int, message string) types.ErrorResp {
+	return types.ErrorResp{Code: types.ErrorCodeOf(status), Message: message, RequestID: types.RequestID(r.Context())}
+}
+
+// fail 以json错误信封响应失败的请求
+func fail(w http.ResponseWriter, r *http.Request, status int, message string) {
+	middleware.Error(w, r, status, message)
+}
+
+// decode 读取并解析json请求体，请求体超出限制时响应413，无法解析时响应400
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeBody(w, r, v, false)
+}
+
+// decodeOptional 读取并解析可选的json请求体，请求体为空时v保持零值，即不按任何条件过滤
+func decodeOptional(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeBody(w, r, v, true)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err == middleware.ErrBodyTooLarge {
+		fail(w, r, http.StatusRequestEntityTooLarge, err.Error())
+		return false
+	}
+	if err != nil {
+		fail(w, r, http.StatusBadRequest, "read request body: "+err.Error())
+		return false
+	}
+	if optional && len(bytes.TrimSpace(body)) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		fail(w, r, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// internalError 响应500，错误详情只记录在日志中
+func internalError(s *types.ServiceCtx, w http.ResponseWriter, r *http.Request, err error) {
+	s.Logger.Error("Request [%s] id:[%s] failure: %s", color.Green(r.URL.Path), color.Green(types.RequestID(r.Context())), err)
+	fail(w, r, http.StatusInternalServerError, "internal error")
+}
+
+// written 按写关注等待从节点确认后响应写请求，超时未满足时返回202及落后的从节点
+func written(s *types.ServiceCtx, w http.ResponseWriter, r *http.Request, rev *types.ConfigRevision, concern types.WriteConcern) {
+	resp := &types.PushConfigResp{Revision: rev.Revision, Hash: rev.Hash}
+	status := http.StatusOK
+	// 选主模式下主节点本地写入不算提交，被集群多数节点应用后才确认，否则主节点切换后写入会被覆盖
+	if s.Consensus != nil && concern != types.WriteAll {
+		concern = types.WriteMajority
+	}
+	if concern != "" && concern != types.WriteLocal {
+		// 等待本次写操作的全局版本，而非之后其他写操作的
+		if !waitAcks(s, concern, rev.Global, resp) {
+			status = http.StatusAccepted
+			s.Logger.Info("Write concern:[%s] revision:[%s] lagging slaves:[%s]", color.Green(concern), color.Green(rev.Global), color.Green(resp.Lagging))
+		}
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		internalError(s, w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(respBytes)
+}
+
+// waitAcks 等待足够的从节点应用全局版本revision，选主模式下需是本任期的写入，失去主节点身份时不再等待
+func waitAcks(s *types.ServiceCtx, concern types.WriteConcern, revision uint64, resp *types.PushConfigResp) bool {
+	var term uint64
+	if s.Consensus != nil {
+		term = s.Consensus.Term()
+	}
+	timeout := time.NewTimer(types.WriteConcernTimeout)
+	defer timeout.Stop()
+	for {
+		changed := s.Acks.Changed()
+
+		acked := make([]string, 0)
+		lagging := make([]string, 0)
+		s.Mux.Lock()
+		for _, sl := range *s.Slaves {
+			// 版本号之前任期的节点未应用本次写入，其历史会被新的主节点替换
+			if sl.Revision >= revision && sl.Term >= term {
+				acked = append(acked, sl.RAddr)
+			} else {
+				lagging = append(lagging, sl.RAddr)
+			}
+		}
+		s.Mux.Unlock()
+		resp.Acked, resp.Lagging = acked, lagging
+
+		if len(acked) >= concern.Acks(len(acked)+len(lagging)) {
+			return true
+		}
+		if s.Consensus != nil && (!s.Consensus.IsLeader() || s.Consensus.Term() != term) {
+			return false
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return false
+		}
+	}
+}
+
+// conflict 以错误信封响应409，并附带配置当前版本
+func conflict(s *types.ServiceCtx, w http.ResponseWriter, r *http.Request, cfgName string) {
+	resp := struct {
+		types.ErrorResp
+		types.PushConfigResp
+	}{ErrorResp: errorResp(r, http.StatusConflict, "config revision conflict")}
+	head, err := history.Head(s.Store, cfgName)
+	if err == nil && head != 0 {
+		resp.Revision = head
+		if cur, err := history.Revision(s.Store, cfgName, head); err == nil {
+			resp.Hash = cur.Hash
+		}
+	}
+
+	respBytes, err := json.Marshal(&resp)
+	if err != nil {
+		internalError(s, w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	w.Write(respBytes)
+}
+
+// RevisionsHandler 配置历史版本列表
+func RevisionsHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.RevisionsReq
+		if !decode(w, r, &c) {
+			return
+		}
+		r, ok := authenticated(s, w, r, "Revisions")
+		if !ok {
+			return
+		}
+		if !permitted(s, w, r, "Revisions", false, c.Name, c.Tag) {
+			return
+		}
+
+		revs, err := history.Revisions(s.Store, fmt.Sprintf(types.ConfigFormat, c.Name, strings.Join(c.Tag, "#")))
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
-		cfgName := fmt.Sprintf(types.ConfigFormat, c.Name, strings.Join(c.Tag, "#"))
-		err = s.Store.Put([]byte(cfgName), []byte(c.Body))
+		respBytes, err := json.Marshal(&types.RevisionsResp{Revisions: revs})
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(http.StatusText(http.StatusOK)))
+		w.Write(respBytes)
+	}
+}
 
-		s.Trigger.Emit(&event.Event{Type: event.PubConfig, Body: map[string]interface{}{"cfgName": cfgName, "cfgMeta": c.Body}})
+// RollbackHandler 回滚配置到指定版本
+func RollbackHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.RollbackReq
+		if !decode(w, r, &c) {
+			return
+		}
+		if !c.WriteConcern.Valid() {
+			fail(w, r, http.StatusBadRequest, "invalid write concern")
+			return
+		}
+		if !permitted(s, w, r, "Rollback", true, c.Name, c.Tag) {
+			return
+		}
+		if c.Author == "" {
+			c.Author = remoteAddr(s, r)
+		}
+
+		cfgName := fmt.Sprintf(types.ConfigFormat, c.Name, strings.Join(c.Tag, "#"))
+		target, err := history.Revision(s.Store, cfgName, c.Revision)
+		if err == history.ErrNoRevision {
+			fail(w, r, http.StatusNotFound, "revision not found")
+			return
+		}
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+		if target.Deleted {
+			fail(w, r, http.StatusBadRequest, "revision is a deletion")
+			return
+		}
+
+		rev, err := history.Commit(s.Store, cfgName, target.Body, c.Author, auditEntry(s, r, types.AuditRollback, c.Author))
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		s.Logger.Info("Config name:[%s] tag:[%s] rollback to revision:[%s]", color.Green(c.Name), color.Green(c.Tag), color.Green(c.Revision))
+
+		s.Trigger.Emit(&event.Event{Type: event.PubConfig, Body: map[string]interface{}{"cfgName": cfgName, "cfgMeta": target.Body}})
 		s.Trigger.Emit(&event.Event{Type: event.SyncConfig})
+
+		written(s, w, r, rev, c.WriteConcern)
 	}
 }
 
-// PullConfigHandler 拉去配置
-func PullConfigHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+// DeleteConfigHandler 删除配置
+func DeleteConfigHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		var c types.DeleteConfigReq
+		if !decode(w, r, &c) {
+			return
+		}
+		if !c.WriteConcern.Valid() {
+			fail(w, r, http.StatusBadRequest, "invalid write concern")
+			return
+		}
+		if !permitted(s, w, r, "Delete", true, c.Name, c.Tag) {
 			return
 		}
+		if c.Author == "" {
+			c.Author = remoteAddr(s, r)
+		}
 
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
+		var expect *types.Precondition
+		if c.ExpectedRevision != nil || c.ExpectedHash != "" {
+			expect = &types.Precondition{Revision: c.ExpectedRevision, Hash: c.ExpectedHash}
+		}
+
+		cfgName := types.ConfigKey(c.Name, c.Tag)
+		rev, err := history.Delete(s.Store, cfgName, c.Author, auditEntry(s, r, types.AuditDelete, c.Author), expect)
+		if err == history.ErrNoRevision {
+			fail(w, r, http.StatusNotFound, "config not found")
+			return
+		}
+		if err == history.ErrConflict {
+			conflict(s, w, r, cfgName)
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
+		s.Logger.Info("Config name:[%s] tag:[%s] deleted", color.Green(c.Name), color.Green(c.Tag))
+
+		s.Trigger.Emit(&event.Event{Type: event.PubConfig, Body: map[string]interface{}{"cfgName": types.TombstoneKey(cfgName), "cfgMeta": cfgName}})
+		s.Trigger.Emit(&event.Event{Type: event.SyncConfig})
+
+		written(s, w, r, rev, c.WriteConcern)
+	}
+}
+
+// PullConfigHandler 拉去配置，请求头Authorization: Bearer <key>携带应用凭证
+func PullConfigHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		var c types.PullConfigReq
-		err = json.Unmarshal(body, &c)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+		if !decode(w, r, &c) {
+			return
+		}
+
+		r, ok := authenticated(s, w, r, "Pull")
+		if !ok {
+			return
+		}
+		if !permitted(s, w, r, "Pull", false, c.Name, c.Tag) {
 			return
 		}
 
-		v, err := s.Store.Get([]byte(fmt.Sprintf("%s#%s", c.Name, strings.Join(c.Tag, "#"))))
+		v, err := s.Store.Get([]byte(types.ConfigKey(c.Name, c.Tag)))
+		if err == store.ErrNotFound {
+			fail(w, r, http.StatusNotFound, "config not found")
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
@@ -235,33 +613,694 @@ func PullConfigHandler(s *types.ServiceCtx, method string) func(w http.ResponseW
 		resp.Body = string(v)
 		respBytes, err := json.Marshal(&resp)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			internalError(s, w, r, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 		w.Write(respBytes)
 	}
 }
 
-func HealthHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+// ListConfigHandler 按名称前缀和标签分页列出配置
+func ListConfigHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
+		var c types.ListConfigReq
+		if !decode(w, r, &c) {
+			return
+		}
+		if c.Limit <= 0 {
+			c.Limit = types.ListLimit
+		}
+		if c.Limit > types.ListMaxLimit {
+			c.Limit = types.ListMaxLimit
+		}
+		r, ok := authenticated(s, w, r, "List")
+		if !ok {
 			return
 		}
 
-		ids := r.URL.Query()["id"]
-		id := ids[0]
-		if id == s.Meta.ID.String() {
+		pairs, err := s.Store.Items("config/" + c.Prefix)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return string(pairs[i].Key) < string(pairs[j].Key)
+		})
+
+		app, _ := types.App(r.Context())
+		resp := types.ListConfigResp{Configs: make([]*types.ConfigSummary, 0)}
+		var last string
+		for _, kv := range pairs {
+			key := string(kv.Key)
+			if key <= c.Cursor {
+				continue
+			}
+			name, tag := types.ParseConfigKey(key)
+			if !hasTags(tag, c.Tags) || !credential.Allowed(s.Store, app, name, tag) {
+				continue
+			}
+			if len(resp.Configs) == c.Limit {
+				resp.Next = last
+				break
+			}
+
+			summary := &types.ConfigSummary{Name: name, Tag: tag, Size: len(kv.Value)}
+			if head, err := history.Head(s.Store, key); err == nil && head != 0 {
+				if rev, err := history.Revision(s.Store, key, head); err == nil {
+					summary.Revision = rev.Revision
+					summary.Hash = rev.Hash
+					summary.Timestamp = rev.Timestamp
+					summary.Author = rev.Author
+				}
+			}
+			resp.Configs = append(resp.Configs, summary)
+			last = key
+		}
+
+		respBytes, err := json.Marshal(&resp)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// hasTags 判断标签是否包含全部指定标签
+func hasTags(tags []string, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Authorize 设置集群令牌或存在应用凭证时校验请求头Authorization: Bearer <token>，集群令牌具备全部角色，
+// 应用凭证需具备路由要求的角色，认证的应用保存在请求上下文中
+func Authorize(s *types.ServiceCtx) middleware.Middleware {
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// 未设置集群令牌时仅在没有应用凭证时开放
+			if s.Token == "" && credential.Empty(s.Store) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := bearer(r)
+			if s.Token != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.Token)) == 1 {
+				next.ServeHTTP(w, r.WithContext(types.WithApp(r.Context(), "")))
+				return
+			}
+			app, ok := credential.Verify(s.Store, key)
+			if !ok {
+				s.Logger.Warn("Request [%s] from:[%s] id:[%s] rejected, invalid token", color.Green(r.URL.Path), color.Green(remoteAddr(s, r)), color.Green(types.RequestID(r.Context())))
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				fail(w, r, http.StatusUnauthorized, "invalid token")
+				return
+			}
+			if !credential.HasRole(s.Store, app, rt.Access) {
+				s.Logger.Warn("Request [%s] app:[%s] from:[%s] id:[%s] denied, role:[%s] required", color.Green(r.URL.Path), color.Green(app), color.Green(remoteAddr(s, r)), color.Green(types.RequestID(r.Context())), color.Green(rt.Access))
+				fail(w, r, http.StatusForbidden, "role "+string(rt.Access)+" required")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(types.WithApp(r.Context(), app)))
+		})
+	}
+}
+
+// bearer 请求头Authorization: Bearer <key>携带的密钥
+func bearer(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// authenticated 认证读取配置的请求，http接口开放时以请求头Authorization: Bearer <key>携带的共享密码或应用凭证认证，
+// 返回的请求上下文中保存认证的应用，认证失败时响应401
+func authenticated(s *types.ServiceCtx, w http.ResponseWriter, r *http.Request, op string) (*http.Request, bool) {
+	if _, ok := types.App(r.Context()); ok {
+		return r, true
+	}
+	app, ok := s.Authenticate(bearer(r))
+	if !ok {
+		s.Logger.Warn("%s from:[%s] id:[%s] denied, invalid credential", op, color.Green(remoteAddr(s, r)), color.Green(types.RequestID(r.Context())))
+		fail(w, r, http.StatusUnauthorized, "invalid credential")
+		return r, false
+	}
+	return r.WithContext(types.WithApp(r.Context(), app)), true
+}
+
+// permitted 判断认证的应用能否读取配置，write时需具备写授权，不能时响应403，未开启http认证时不限制
+func permitted(s *types.ServiceCtx, w http.ResponseWriter, r *http.Request, op string, write bool, name string, tags []string) bool {
+	app, _ := types.App(r.Context())
+	allowed := credential.Allowed
+	if write {
+		allowed = credential.Writable
+	}
+	if allowed(s.Store, app, name, tags) {
+		return true
+	}
+	s.Logger.Warn("%s config name:[%s] tag:[%s] app:[%s] from:[%s] id:[%s] denied", op, color.Green(name), color.Green(tags), color.Green(app), color.Green(remoteAddr(s, r)), color.Green(types.RequestID(r.Context())))
+	fail(w, r, http.StatusForbidden, "config not granted")
+	return false
+}
+
+// Forward 将写请求转发到主节点，raft模式下转发到当前主节点，并返回主节点的响应
+func Forward(h HandlerFunc) HandlerFunc {
+	return func(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+		next := h(s, method)
+		return func(w http.ResponseWriter, r *http.Request) {
+			master, isMaster := s.Master, s.Meta.Role == types.RoleMaster
+			if s.Consensus != nil {
+				master, isMaster = s.Consensus.Leader(), s.Consensus.IsLeader()
+			}
+			if isMaster {
+				next(w, r)
+				return
+			}
+			if master != "" {
+				w.Header().Set(types.LeaderHeader, master)
+			}
+
+			// relay slaves forward through their upstreams, a request never goes back to a node it
+			// passed, which happens when the raft leader changed in between
+			hops := make([]string, 0)
+			if forwarded := r.Header.Get(types.ForwardedHeader); forwarded != "" {
+				hops = strings.Split(forwarded, ",")
+			}
+			if master == "" || types.NewChain(hops...).Contains(master, s.Meta.RAddr) {
+				fail(w, r, http.StatusServiceUnavailable, "master unavailable")
+				return
+			}
+			hops = append(hops, s.Meta.RAddr)
+
+			proxy := &httputil.ReverseProxy{
+				Director: func(req *http.Request) {
+					req.URL.Scheme = s.Peer.Scheme()
+					req.URL.Host = master
+					req.Host = master
+					req.Header.Set(types.ForwardedHeader, strings.Join(hops, ","))
+				},
+				ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+					s.Logger.Info("Forward [%s] id:[%s] to master:[%s] failure: %s", color.Green(req.URL.Path), color.Green(types.RequestID(req.Context())), color.Green(master), err)
+					fail(w, req, http.StatusBadGateway, "forward to master failure")
+				},
+				// the request id header was already set by this node, master echoes the same id
+				ModifyResponse: func(resp *http.Response) error {
+					resp.Header.Del(types.RequestIDHeader)
+					return nil
+				},
+				Transport: s.Peer.RoundTripper(),
+			}
+			proxy.ServeHTTP(w, r)
+		}
+	}
+}
+
+// Peer 节点间的请求，开启双向tls时要求对方出示ca签发的证书
+func Peer(h HandlerFunc) HandlerFunc {
+	return func(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+		next := h(s, method)
+		return func(w http.ResponseWriter, r *http.Request) {
+			if s.MutualTLS && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+				s.Logger.Warn("Peer request [%s] from:[%s] id:[%s] rejected, no client certificate", color.Green(r.URL.Path), color.Green(r.RemoteAddr), color.Green(types.RequestID(r.Context())))
+				fail(w, r, http.StatusForbidden, "client certificate required")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// VoteHandler raft投票
+func VoteHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.VoteReq
+		if !decode(w, r, &req) {
+			return
+		}
+
+		respBytes, err := json.Marshal(s.Consensus.Vote(&req))
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// AppendHandler raft主节点心跳
+func AppendHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.AppendReq
+		if !decode(w, r, &req) {
+			return
+		}
+
+		respBytes, err := json.Marshal(s.Consensus.Append(&req))
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// DigestHandler 从节点配置的哈希树摘要
+func DigestHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		digest, err := merkle.Digest(s.Store)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		respBytes, err := json.Marshal(digest)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// RepairHandler 修复从节点与主节点不一致的配置区间
+func RepairHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.RepairReq
+		if !decode(w, r, &req) {
+			return
+		}
+
+		changes, err := replication.Repair(s.Store, &req)
+		if err == replication.ErrStaleRepair {
+			fail(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+		for _, c := range changes {
+			s.Logger.Info("Config name:[%s] tag:[%s] deleted:[%t] repaired", color.Green(c.Name), color.Green(c.Tag), c.Deleted)
+		}
+		for _, ev := range replication.Events(changes) {
+			s.Trigger.Emit(ev)
+		}
+
+		respBytes, err := json.Marshal(&types.RepairResp{Repaired: len(changes)})
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// CreateCredentialHandler 为应用创建rpc客户端及http接口凭证，密钥只在响应中返回一次，需设置集群令牌
+func CreateCredentialHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.CreateCredentialReq
+		if !decode(w, r, &c) {
+			return
+		}
+		// 没有集群令牌时节点之间的请求无法通过认证
+		if s.Token == "" {
+			fail(w, r, http.StatusConflict, "credentials require a cluster token")
+			return
+		}
+
+		key, err := credential.Create(s.Store, c.App, c.Grants, c.Roles)
+		if err == credential.ErrInvalidApp || err == credential.ErrInvalidGrant || err == credential.ErrInvalidRole {
+			fail(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == credential.ErrExists {
+			fail(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		s.Logger.Info("Credential app:[%s] created", color.Green(c.App))
+		s.Trigger.Emit(&event.Event{Type: event.SyncCredentials})
+
+		respBytes, err := json.Marshal(&types.CreateCredentialResp{App: c.App, Key: key})
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// GrantHandler 替换应用凭证可访问的配置及角色，使用该凭证的rpc客户端会被断开并按新授权重连
+func GrantHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.GrantReq
+		if !decode(w, r, &c) {
+			return
+		}
+
+		err := credential.Grant(s.Store, c.App, c.Grants, c.Roles)
+		if err == credential.ErrInvalidGrant || err == credential.ErrInvalidRole {
+			fail(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == credential.ErrNotFound {
+			fail(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		s.Logger.Info("Credential app:[%s] grants:[%s] updated", color.Green(c.App), color.Green(len(c.Grants)))
+		s.Trigger.Emit(&event.Event{Type: event.RevokeCredential, Body: map[string]interface{}{"app": c.App}})
+		s.Trigger.Emit(&event.Event{Type: event.SyncCredentials})
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// RevokeCredentialHandler 吊销应用凭证，并断开使用该凭证的rpc客户端
+func RevokeCredentialHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.RevokeCredentialReq
+		if !decode(w, r, &c) {
+			return
+		}
+
+		err := credential.Revoke(s.Store, c.App)
+		if err == credential.ErrNotFound {
+			fail(w, r, http.StatusNotFound, err.Error())
+			return
+		}
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		s.Logger.Info("Credential app:[%s] revoked", color.Green(c.App))
+		s.Trigger.Emit(&event.Event{Type: event.RevokeCredential, Body: map[string]interface{}{"app": c.App}})
+		s.Trigger.Emit(&event.Event{Type: event.SyncCredentials})
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// CredentialsHandler 列出应用凭证，不包含密钥哈希
+func CredentialsHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := credential.Snapshot(s.Store)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+		for _, c := range snapshot.Credentials {
+			c.Hash = ""
+		}
+
+		respBytes, err := json.Marshal(&types.CredentialsResp{Version: snapshot.Version, Credentials: snapshot.Credentials})
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// SyncCredentialsHandler 主从同步应用凭证，凭证被吊销或授权变更的rpc客户端会被断开
+func SyncCredentialsHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.SyncCredentialsReq
+		if !decode(w, r, &req) {
+			return
+		}
+
+		revoked, err := credential.Apply(s.Store, &req)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+		s.Logger.Info("Slave credentials version:[%s] count:[%s] sync success", color.Green(req.Version), color.Green(len(req.Credentials)))
+		for _, app := range revoked {
+			s.Trigger.Emit(&event.Event{Type: event.RevokeCredential, Body: map[string]interface{}{"app": app}})
+		}
+		// a relay slave fans the credentials out to its downstream slaves
+		if s.Slaves != nil {
+			s.Trigger.Emit(&event.Event{Type: event.SyncCredentials})
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(http.StatusText(http.StatusOK)))
+	}
+}
+
+// AuditHandler 按时间、配置名称及标签分页查询审计日志
+func AuditHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.AuditReq
+		if !decodeOptional(w, r, &c) {
+			return
+		}
+		if c.Limit <= 0 {
+			c.Limit = types.ListLimit
+		}
+		if c.Limit > types.ListMaxLimit {
+			c.Limit = types.ListMaxLimit
+		}
+
+		entries, next, err := audit.Query(s.Store, &c, c.Limit)
+		if err == audit.ErrInvalidFilter {
+			fail(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		respBytes, err := json.Marshal(&types.AuditResp{Entries: entries, Next: next})
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// ExportAuditHandler 按查询条件导出全部审计日志，每行一条json
+func ExportAuditHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var c types.AuditReq
+		if !decodeOptional(w, r, &c) {
+			return
+		}
+		if c.Name != "" {
+			if _, err := path.Match(c.Name, ""); err != nil {
+				fail(w, r, http.StatusBadRequest, audit.ErrInvalidFilter.Error())
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", "attachment; filename=audit.jsonl")
+		w.WriteHeader(http.StatusOK)
+		if err := audit.Export(s.Store, &c, w); err != nil {
+			s.Logger.Error("Export audit log failure: %s", err)
+		}
+	}
+}
+
+// ClusterHandler 集群成员及状态
+func ClusterHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := history.Current(s.Store)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		master := *s.Meta
+		master.Revision = current
+		master.Clients = s.Clients()
+		resp := &types.ClusterResp{
+			Master: &types.NodeStatus{ServerMetadata: master},
+			Slaves: make([]*types.NodeStatus, 0),
+		}
+		// 选主模式下主节点元数据中没有任期，取当前任期及最后应用版本的任期
+		if s.Consensus != nil {
+			resp.Master.Term, err = history.LastTerm(s.Store)
+			if err != nil {
+				internalError(s, w, r, err)
+				return
+			}
+			resp.Master.CurrentTerm = s.Consensus.Term()
+		}
+
+		s.Mux.Lock()
+		resp.AntiEntropy = *s.AntiEntropy
+		if s.HealthEvents != nil {
+			resp.Events = append(resp.Events, *s.HealthEvents...)
+		}
+		for _, sl := range *s.Slaves {
+			resp.Slaves = append(resp.Slaves, nodeStatus(sl, current))
+		}
+		s.Mux.Unlock()
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			internalError(s, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// MetricsHandler 本节点http接口的请求数及延迟，prometheus文本格式
+func MetricsHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		if _, err := s.Metrics.WriteTo(w); err != nil {
+			s.Logger.Error("Write metrics failure: %s", err)
+		}
+	}
+}
+
+// nodeStatus 节点状态，中继从节点包含其下游从节点
+func nodeStatus(sl *types.ServerMetadata, current uint64) *types.NodeStatus {
+	st := &types.NodeStatus{ServerMetadata: *sl}
+	if sl.Revision < current {
+		st.Lag = current - sl.Revision
+	}
+	for _, downstream := range sl.Downstream {
+		st.Downstream = append(st.Downstream, nodeStatus(downstream, current))
+	}
+	return st
+}
+
+// sourceIP 请求来源ip，转发的写请求取原始客户端ip
+func sourceIP(s *types.ServiceCtx, r *http.Request) string {
+	addr := remoteAddr(s, r)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// remoteAddr 请求来源地址，节点转发的写请求取原始客户端地址
+func remoteAddr(s *types.ServiceCtx, r *http.Request) string {
+	forwarded := r.Header.Get(types.ForwardedHeader)
+	if forwarded == "" || !fromPeer(s, r) {
+		return r.RemoteAddr
+	}
+	// 经过的每个节点都在X-Forwarded-For末尾追加了它收到请求的地址，第一个节点追加的是客户端地址，
+	// 之前的由客户端自行设置，不可信
+	xff := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	i := len(xff) - len(strings.Split(forwarded, ","))
+	if i < 0 {
+		return r.RemoteAddr
+	}
+	return strings.TrimSpace(xff[i])
+}
+
+// fromPeer 请求是否来自集群节点，即出示了ca签发的证书，或携带集群令牌
+func fromPeer(s *types.ServiceCtx, r *http.Request) bool {
+	if s.MutualTLS && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	token := r.Header.Get(peer.TokenHeader)
+	if token == "" {
+		token = bearer(r)
+	}
+	return s.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// HealthHandler 健康检查，id与本节点不符时返回404
+func HealthHandler(s *types.ServiceCtx, method string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			fail(w, r, http.StatusBadRequest, "missing id")
+			return
+		}
+		if !s.Status.Ready() {
+			fail(w, r, http.StatusServiceUnavailable, "node not ready")
+			return
+		}
+		if id == s.Meta.ID.String() {
+			applied, err := history.Current(s.Store)
+			if err != nil {
+				internalError(s, w, r, err)
+				return
+			}
+			version, err := credential.Version(s.Store)
+			if err != nil {
+				internalError(s, w, r, err)
+				return
+			}
+			health := &types.HealthResp{ID: s.Meta.ID, Revision: applied, Clients: s.Clients(), RPCAddr: s.Meta.RPCAddr, Credentials: version}
+			if s.Slaves != nil {
+				s.Mux.Lock()
+				for _, sl := range *s.Slaves {
+					downstream := *sl
+					health.Downstream = append(health.Downstream, &downstream)
+				}
+				s.Mux.Unlock()
+			}
+			respBytes, err := json.Marshal(health)
+			if err != nil {
+				internalError(s, w, r, err)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(http.StatusText(http.StatusOK)))
+			w.Write(respBytes)
 		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			fail(w, r, http.StatusNotFound, "node id mismatch")
 		}
 	}
 }
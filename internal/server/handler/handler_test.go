@@ -0,0 +1,562 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
+	"github.com/Jarnpher553/gonfig/internal/server/event"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/middleware"
+	"github.com/Jarnpher553/gonfig/internal/server/peer"
+	"github.com/Jarnpher553/gonfig/internal/server/replication"
+	"github.com/Jarnpher553/gonfig/internal/server/route"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	alog "github.com/lesismal/arpc/log"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCtx() *types.ServiceCtx {
+	log := &logger.XLogger{}
+	log.SetLevel(alog.LevelNone)
+	return &types.ServiceCtx{
+		Meta:   &types.ServerMetadata{ID: uuid.NewV4()},
+		Store:  store.NewMapStore(),
+		Logger: log,
+		Status: &types.Status{},
+		// open http api without a shared password
+		Authenticate: func(key string) (string, bool) { return "", key == "" },
+	}
+}
+
+func TestErrorResp(t *testing.T) {
+	s := testCtx()
+	if _, err := history.Commit(s.Store, types.ConfigKey("web", []string{"prod"}), "v1", "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := uint64(5)
+	stale, _ := json.Marshal(&types.PushConfigReq{Name: "web", Tag: []string{"prod"}, Body: "v2", ExpectedRevision: &expected})
+
+	tests := []struct {
+		method  string
+		url     string
+		h       HandlerFunc
+		body    string
+		chunked bool
+		ready   bool
+		status  int
+	}{
+		{http.MethodPost, "/push", PushConfigHandler, "{", false, true, http.StatusBadRequest},
+		{http.MethodPost, "/push", PushConfigHandler, `{"Name":"web","WriteConcern":"quorum"}`, false, true, http.StatusBadRequest},
+		{http.MethodPost, "/push", PushConfigHandler, `{"Name":"web","Body":"` + strings.Repeat("x", 128) + `"}`, false, true, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/push", PushConfigHandler, `{"Name":"web","Body":"` + strings.Repeat("x", 128) + `"}`, true, true, http.StatusRequestEntityTooLarge},
+		{http.MethodPost, "/push", PushConfigHandler, string(stale), false, true, http.StatusConflict},
+		{http.MethodPost, "/rollback", RollbackHandler, `{"Name":"web","Tag":["prod"],"Revision":9}`, false, true, http.StatusNotFound},
+		{http.MethodPost, "/delete", DeleteConfigHandler, `{"Name":"api","Tag":["prod"]}`, false, true, http.StatusNotFound},
+		{http.MethodPost, "/pull", PullConfigHandler, `{"Name":"web"}`, false, true, http.StatusUnauthorized},
+		{http.MethodGet, "/health", HealthHandler, "", false, true, http.StatusBadRequest},
+		{http.MethodGet, "/health?id=" + uuid.NewV4().String(), HealthHandler, "", false, true, http.StatusNotFound},
+		{http.MethodGet, "/health?id=" + s.Meta.ID.String(), HealthHandler, "", false, false, http.StatusServiceUnavailable},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("ErrorResp_%d", i), func(t *testing.T) {
+			s.Authenticate = func(key string) (string, bool) { return "", false }
+			s.Status.SetReady(test.ready)
+			path := strings.SplitN(test.url, "?", 2)[0]
+			rt := route.NewRouter(test.method, path, types.AccessWriter)
+			h := middleware.Chain(rt, http.HandlerFunc(test.h(s, test.method)), middleware.RequestID(), middleware.BodyLimit(128))
+
+			r := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if test.chunked {
+				r.ContentLength = -1
+			}
+			id := fmt.Sprintf("req-%d", i)
+			r.Header.Set(types.RequestIDHeader, id)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			var e types.ErrorResp
+			if w.Code != test.status || w.Header().Get("Content-Type") != "application/json" {
+				t.FailNow()
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Code != types.ErrorCodeOf(test.status) || e.Message == "" || e.RequestID != id {
+				t.FailNow()
+			}
+		})
+	}
+
+	t.Run("ErrorResp_Conflict", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(string(stale)))
+		w := httptest.NewRecorder()
+		PushConfigHandler(s, http.MethodPost)(w, r)
+		var resp types.PushConfigResp
+		if w.Code != http.StatusConflict || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Revision != 1 || resp.Hash == "" {
+			t.FailNow()
+		}
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	s := testCtx()
+	h := func(access types.Access) http.Handler {
+		rt := route.NewRouter(http.MethodPost, "/push", access)
+		return middleware.Chain(rt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			app, ok := types.App(r.Context())
+			if !ok {
+				app = "open"
+			}
+			w.Write([]byte(app))
+		}), middleware.RequestID(), Authorize(s))
+	}
+
+	tests := []struct {
+		token  string
+		create bool
+		key    string
+		access types.Access
+		status int
+		app    string
+	}{
+		// no token and no credential, the api is open
+		{"", false, "", types.AccessAdmin, http.StatusOK, "open"},
+		{"clustersecret", false, "", types.AccessReader, http.StatusUnauthorized, ""},
+		{"clustersecret", false, "Bearer guess", types.AccessReader, http.StatusUnauthorized, ""},
+		{"clustersecret", false, "Bearer clustersecret", types.AccessAdmin, http.StatusOK, ""},
+		// credentials are stored, the api is closed even without a token
+		{"", true, "", types.AccessReader, http.StatusUnauthorized, ""},
+		{"", false, "Bearer ", types.AccessReader, http.StatusUnauthorized, ""},
+		{"", false, "Bearer web", types.AccessReader, http.StatusOK, "web"},
+		{"", false, "Bearer web", types.AccessWriter, http.StatusForbidden, ""},
+		{"clustersecret", false, "Bearer web", types.AccessReader, http.StatusOK, "web"},
+		{"clustersecret", false, "Bearer clustersecret", types.AccessWriter, http.StatusOK, ""},
+	}
+	var key string
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Authorize_%d", i), func(t *testing.T) {
+			if test.create {
+				var err error
+				key, err = credential.Create(s.Store, "web", []*types.Grant{{Name: "web"}}, []types.Access{types.AccessReader})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Token = test.token
+			r := httptest.NewRequest(http.MethodPost, "/push", nil)
+			if test.key != "" {
+				r.Header.Set("Authorization", strings.Replace(test.key, "Bearer web", "Bearer "+key, 1))
+			}
+			w := httptest.NewRecorder()
+			h(test.access).ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.FailNow()
+			}
+			if test.status == http.StatusOK && w.Body.String() != test.app {
+				t.FailNow()
+			}
+			if test.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	s := &types.ServiceCtx{Token: "clustersecret"}
+	tests := []struct {
+		header map[string]string
+		tls    bool
+		addr   string
+	}{
+		{nil, false, "192.0.2.1:1234"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019", "X-Forwarded-For": "10.0.0.9"}, false, "192.0.2.1:1234"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019", "X-Forwarded-For": "10.0.0.9", peer.TokenHeader: "guess"}, false, "192.0.2.1:1234"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019", "X-Forwarded-For": "10.0.0.9", peer.TokenHeader: "clustersecret"}, false, "10.0.0.9"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019", "X-Forwarded-For": "6.6.6.6, 10.0.0.9", peer.TokenHeader: "clustersecret"}, false, "10.0.0.9"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019,10.0.0.3:9019", "X-Forwarded-For": "6.6.6.6, 10.0.0.9, 10.0.0.2", "Authorization": "Bearer clustersecret"}, false, "10.0.0.9"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019,10.0.0.3:9019", "X-Forwarded-For": "10.0.0.9", peer.TokenHeader: "clustersecret"}, false, "192.0.2.1:1234"},
+		{map[string]string{types.ForwardedHeader: "10.0.0.2:9019", "X-Forwarded-For": "6.6.6.6, 10.0.0.9"}, true, "10.0.0.9"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("RemoteAddr_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/push", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			for k, v := range test.header {
+				r.Header.Set(k, v)
+			}
+			s.MutualTLS = test.tls
+			if test.tls {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}
+			}
+			if remoteAddr(s, r) != test.addr {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestListConfig(t *testing.T) {
+	s := testCtx()
+	for _, key := range []string{
+		types.ConfigKey("web", []string{"prod"}),
+		types.ConfigKey("web", []string{"dev"}),
+		types.ConfigKey("web-api", []string{"prod", "eu"}),
+		types.ConfigKey("billing", []string{"prod"}),
+		types.ConfigKey("api", nil),
+	} {
+		if _, err := history.Commit(s.Store, key, "v1", "alice", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		tags   []string
+		limit  int
+		want   []string
+		pages  int
+	}{
+		{"", nil, 0, []string{"api#", "billing#prod", "web-api#prod#eu", "web#dev", "web#prod"}, 1},
+		{"web", nil, 0, []string{"web-api#prod#eu", "web#dev", "web#prod"}, 1},
+		{"web/", nil, 0, []string{"web#dev", "web#prod"}, 1},
+		{"", []string{"prod"}, 0, []string{"billing#prod", "web-api#prod#eu", "web#prod"}, 1},
+		{"", []string{"eu", "prod"}, 0, []string{"web-api#prod#eu"}, 1},
+		{"", nil, 2, []string{"api#", "billing#prod", "web-api#prod#eu", "web#dev", "web#prod"}, 3},
+		{"web", []string{"prod"}, 1, []string{"web-api#prod#eu", "web#prod"}, 2},
+		{"", nil, 5, []string{"api#", "billing#prod", "web-api#prod#eu", "web#dev", "web#prod"}, 1},
+		{"nothing", nil, 0, []string{}, 1},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("ListConfig_%d", i), func(t *testing.T) {
+			got := make([]string, 0)
+			var cursor string
+			pages := 0
+			for {
+				body, _ := json.Marshal(&types.ListConfigReq{Prefix: test.prefix, Tags: test.tags, Limit: test.limit, Cursor: cursor})
+				w := httptest.NewRecorder()
+				ListConfigHandler(s, http.MethodPost)(w, httptest.NewRequest(http.MethodPost, "/list", strings.NewReader(string(body))))
+				var resp types.ListConfigResp
+				if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+					t.FailNow()
+				}
+				pages++
+				for _, c := range resp.Configs {
+					if c.Revision != 1 || c.Author != "alice" || c.Size != 2 || (c.Name == "api" && c.Tag != nil) {
+						t.FailNow()
+					}
+					got = append(got, c.Name+"#"+strings.Join(c.Tag, "#"))
+				}
+				if resp.Next == "" {
+					break
+				}
+				cursor = resp.Next
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") || pages != test.pages {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+//consensus raft node of a fixed term and leadership
+type consensus struct {
+	types.Consensus
+	term   uint64
+	leader bool
+}
+
+func (c *consensus) Term() uint64 {
+	return c.term
+}
+
+func (c *consensus) IsLeader() bool {
+	return c.leader
+}
+
+func TestWaitAcks(t *testing.T) {
+	timeout := types.WriteConcernTimeout
+	defer func() { types.WriteConcernTimeout = timeout }()
+	types.WriteConcernTimeout = 50 * time.Millisecond
+
+	tests := []struct {
+		concern   types.WriteConcern
+		revisions []uint64
+		terms     []uint64
+		consensus *consensus
+		// late revision applied by the first slave while waiting
+		late    uint64
+		ok      bool
+		acked   int
+		lagging int
+	}{
+		{types.WriteMajority, []uint64{5, 5, 4}, nil, nil, 0, true, 2, 1},
+		{types.WriteMajority, []uint64{6, 4, 4}, nil, nil, 0, false, 1, 2},
+		{types.WriteMajority, []uint64{4, 4}, nil, nil, 0, false, 0, 2},
+		{types.WriteMajority, []uint64{4, 4, 5}, nil, nil, 5, true, 2, 1},
+		{types.WriteAll, []uint64{5, 7, 4}, nil, nil, 0, false, 2, 1},
+		{types.WriteAll, []uint64{4, 7}, nil, nil, 5, true, 2, 0},
+		{types.WriteAll, nil, nil, nil, 0, true, 0, 0},
+		// revisions of an earlier raft term are not acknowledgements
+		{types.WriteMajority, []uint64{5, 5}, []uint64{2, 1}, &consensus{term: 2, leader: true}, 0, true, 1, 1},
+		{types.WriteAll, []uint64{5, 5}, []uint64{2, 1}, &consensus{term: 2, leader: true}, 0, false, 1, 1},
+		// a node which is no longer leader stops waiting
+		{types.WriteAll, []uint64{5, 4}, []uint64{2, 2}, &consensus{term: 2}, 0, false, 1, 1},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("WaitAcks_%d", i), func(t *testing.T) {
+			slaves := make(types.Slaves, 0)
+			for j, rev := range test.revisions {
+				sl := &types.ServerMetadata{RAddr: fmt.Sprintf("10.0.0.%d:9019", j), Revision: rev}
+				if test.terms != nil {
+					sl.Term = test.terms[j]
+				}
+				slaves = append(slaves, sl)
+			}
+			s := testCtx()
+			s.Slaves, s.Mux, s.Acks = &slaves, &sync.Mutex{}, types.NewAcks()
+			if test.consensus != nil {
+				s.Consensus = test.consensus
+			}
+			if test.late != 0 {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					s.Mux.Lock()
+					slaves[0].Revision = test.late
+					s.Mux.Unlock()
+					s.Acks.Notify()
+				}()
+			}
+
+			var resp types.PushConfigResp
+			start := time.Now()
+			ok := waitAcks(s, test.concern, 5, &resp)
+			if ok != test.ok || len(resp.Acked) != test.acked || len(resp.Lagging) != test.lagging {
+				t.FailNow()
+			}
+			if ok && time.Since(start) >= types.WriteConcernTimeout {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestCluster(t *testing.T) {
+	tests := []struct {
+		consensus   *consensus
+		lastTerm    string
+		term        uint64
+		currentTerm uint64
+	}{
+		{nil, "", 0, 0},
+		{&consensus{term: 3, leader: true}, "2", 2, 3},
+		{&consensus{term: 3, leader: true}, "", 0, 3},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Cluster_%d", i), func(t *testing.T) {
+			s := testCtx()
+			slaves := make(types.Slaves, 0)
+			s.Slaves, s.Mux, s.AntiEntropy = &slaves, &sync.Mutex{}, &types.AntiEntropyStatus{}
+			s.Clients = func() int { return 0 }
+			if test.consensus != nil {
+				s.Consensus = test.consensus
+			}
+			if test.lastTerm != "" {
+				s.Store.Put([]byte(types.LastTermKey), []byte(test.lastTerm))
+			}
+			w := httptest.NewRecorder()
+			ClusterHandler(s, http.MethodGet)(w, httptest.NewRequest(http.MethodGet, "/cluster", nil))
+			var resp types.ClusterResp
+			if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+				t.FailNow()
+			}
+			if resp.Master.Term != test.term || resp.Master.CurrentTerm != test.currentTerm {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestRegisterSlave(t *testing.T) {
+	s := testCtx()
+	slaves := make(types.Slaves, 0)
+	s.Slaves, s.Mux = &slaves, &sync.Mutex{}
+	// a relay slave replicating from master
+	s.Chain = types.NewChain("10.0.0.2:8888", "10.0.0.1:9019")
+	id := uuid.NewV4()
+
+	tests := []struct {
+		req    types.SlaveMetaReq
+		status int
+		slaves int
+	}{
+		{types.SlaveMetaReq{ID: uuid.NewV4(), Addr: "10.0.0.1:9019"}, http.StatusConflict, 0},
+		{types.SlaveMetaReq{ID: uuid.NewV4(), Addr: "10.0.0.2:8888"}, http.StatusConflict, 0},
+		{types.SlaveMetaReq{ID: uuid.NewV4(), Addr: "10.0.0.3:8888", Descendants: []string{"10.0.0.4:8888", "10.0.0.1:9019"}}, http.StatusConflict, 0},
+		{types.SlaveMetaReq{ID: id, Addr: "10.0.0.3:8888", Descendants: []string{"10.0.0.4:8888"}}, http.StatusOK, 1},
+		// a slave registering again replaces its entry
+		{types.SlaveMetaReq{ID: id, Addr: "10.0.0.3:8888", Revision: 3}, http.StatusOK, 1},
+		{types.SlaveMetaReq{ID: uuid.NewV4(), Addr: "10.0.0.5:8888"}, http.StatusOK, 2},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("RegisterSlave_%d", i), func(t *testing.T) {
+			body, _ := json.Marshal(&test.req)
+			w := httptest.NewRecorder()
+			RegisterSlaveHandler(s, http.MethodPost)(w, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(string(body))))
+			if w.Code != test.status || len(slaves) != test.slaves {
+				t.FailNow()
+			}
+			if test.status == http.StatusConflict {
+				var e types.ErrorResp
+				if json.Unmarshal(w.Body.Bytes(), &e) != nil || e.Code != types.CodeConflict {
+					t.FailNow()
+				}
+				return
+			}
+			var resp types.RegisterResp
+			if json.Unmarshal(w.Body.Bytes(), &resp) != nil || strings.Join(resp.Chain, ",") != "10.0.0.2:8888,10.0.0.1:9019" {
+				t.FailNow()
+			}
+		})
+	}
+	if slaves[0].Revision != 3 {
+		t.FailNow()
+	}
+}
+
+func TestSyncRelay(t *testing.T) {
+	master := store.NewMapStore()
+	if _, err := history.Commit(master, types.ConfigKey("web", []string{"prod"}), "v1", "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+	req, err := replication.Delta(master, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(req)
+
+	tests := []struct {
+		relay bool
+		syncs int
+		// events of the relay fanning out the changes to its slaves
+		fanouts int
+	}{
+		{false, 1, 0},
+		{true, 1, 1},
+		// changes already applied are not fanned out again
+		{true, 2, 1},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("SyncRelay_%d", i), func(t *testing.T) {
+			s := testCtx()
+			trigger := make(event.Trigger, 10)
+			s.Trigger = trigger
+			if test.relay {
+				slaves := make(types.Slaves, 0)
+				s.Slaves, s.Mux = &slaves, &sync.Mutex{}
+			}
+			for j := 0; j < test.syncs; j++ {
+				w := httptest.NewRecorder()
+				SyncConfigurationHandler(s, http.MethodPost)(w, httptest.NewRequest(http.MethodPost, "/sync", strings.NewReader(string(body))))
+				var resp types.SyncConfigResp
+				if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Revision != req.Revision {
+					t.FailNow()
+				}
+			}
+			close(trigger)
+			fanouts, pubs := 0, 0
+			for ev := range trigger {
+				switch ev.Type {
+				case event.SyncConfig:
+					fanouts++
+				case event.PubConfig:
+					pubs++
+				}
+			}
+			if fanouts != test.fanouts || pubs != 1 {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestReadAuth(t *testing.T) {
+	s := testCtx()
+	s.Authenticate = func(key string) (string, bool) { return "", key == "secret" }
+	if _, err := history.Commit(s.Store, types.ConfigKey("web", nil), "v1", "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url    string
+		h      HandlerFunc
+		body   string
+		key    string
+		app    bool
+		status int
+	}{
+		{"/pull", PullConfigHandler, `{"Name":"web"}`, "", false, http.StatusUnauthorized},
+		{"/pull", PullConfigHandler, `{"Name":"web"}`, "secret", false, http.StatusOK},
+		{"/revisions", RevisionsHandler, `{"Name":"web"}`, "", false, http.StatusUnauthorized},
+		{"/revisions", RevisionsHandler, `{"Name":"web"}`, "guess", false, http.StatusUnauthorized},
+		{"/revisions", RevisionsHandler, `{"Name":"web"}`, "secret", false, http.StatusOK},
+		{"/list", ListConfigHandler, `{}`, "", false, http.StatusUnauthorized},
+		{"/list", ListConfigHandler, `{}`, "secret", false, http.StatusOK},
+		// requests authenticated by Authorize
+		{"/revisions", RevisionsHandler, `{"Name":"web"}`, "", true, http.StatusOK},
+		{"/list", ListConfigHandler, `{}`, "", true, http.StatusOK},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("ReadAuth_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.url, strings.NewReader(test.body))
+			if test.key != "" {
+				r.Header.Set("Authorization", "Bearer "+test.key)
+			}
+			if test.app {
+				r = r.WithContext(types.WithApp(r.Context(), ""))
+			}
+			w := httptest.NewRecorder()
+			test.h(s, http.MethodPost)(w, r)
+			if w.Code != test.status {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestDecodeOptional(t *testing.T) {
+	tests := []struct {
+		body   string
+		chunk  bool
+		ok     bool
+		name   string
+		status int
+	}{
+		{"", false, true, "", http.StatusOK},
+		{"", true, true, "", http.StatusOK},
+		{" \n", false, true, "", http.StatusOK},
+		{`{"Name":"web"}`, false, true, "web", http.StatusOK},
+		{`{"Name":"web"}`, true, true, "web", http.StatusOK},
+		{`{"Name":`, false, false, "", http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("DecodeOptional_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/audit", strings.NewReader(test.body))
+			if test.chunk {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			var c types.AuditReq
+			if decodeOptional(w, r, &c) != test.ok || c.Name != test.name || w.Code != test.status {
+				t.FailNow()
+			}
+		})
+	}
+}
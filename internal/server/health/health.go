@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/peer"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"net/http"
+	"time"
+)
+
+//Checker probe the health of a slave, a check must give up once ctx is done
+type Checker interface {
+	Check(ctx context.Context, sl *types.ServerMetadata) (*types.HealthResp, error)
+}
+
+//HTTPChecker check a slave through its /health endpoint
+type HTTPChecker struct {
+	//Client client between nodes, plain http is used if nil
+	Client *peer.Client
+}
+
+//Check get /health of the slave
+func (c HTTPChecker) Check(ctx context.Context, sl *types.ServerMetadata) (*types.HealthResp, error) {
+	client := c.Client
+	if client == nil {
+		client = peer.New(nil, "")
+	}
+	url := client.URL(sl.RAddr, fmt.Sprintf("/health?id=%s", sl.ID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("health check failure: %s", resp.Status)
+	}
+	var health types.HealthResp
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+//Observe record the result of a check on the slave and move it between alive, suspect and dead,
+//it returns the transition or nil if the state is unchanged. the caller must guard sl
+func Observe(cfg *types.HealthCfg, sl *types.ServerMetadata, ok bool, now time.Time) *types.HealthEvent {
+	from := sl.State
+	if from == "" {
+		from = types.HealthAlive
+	}
+
+	to := from
+	if ok {
+		sl.Failures = 0
+		sl.LastHealthCheck = now.Unix()
+		sl.SuspectSince = 0
+		to = types.HealthAlive
+	} else {
+		sl.Failures++
+		switch from {
+		case types.HealthAlive:
+			if sl.Failures >= cfg.FailureThreshold {
+				to = types.HealthSuspect
+				sl.SuspectSince = now.Unix()
+			}
+		case types.HealthSuspect:
+			if now.Sub(time.Unix(sl.SuspectSince, 0)) >= cfg.Grace {
+				to = types.HealthDead
+			}
+		}
+	}
+	sl.State = to
+
+	if to == from {
+		return nil
+	}
+	return &types.HealthEvent{
+		ID:       sl.ID,
+		Addr:     sl.RAddr,
+		From:     from,
+		To:       to,
+		Failures: sl.Failures,
+		Time:     now.Unix(),
+	}
+}
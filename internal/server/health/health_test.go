@@ -0,0 +1,41 @@
+package health
+
+import (
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	cfg := &types.HealthCfg{Interval: time.Second, Timeout: time.Second, FailureThreshold: 2, Grace: 10 * time.Second}
+	sl := &types.ServerMetadata{RAddr: "127.0.0.1:8888"}
+	start := time.Unix(1000, 0)
+
+	cases := []struct {
+		ok    bool
+		after time.Duration
+		state types.HealthState
+		event bool
+	}{
+		{true, 0, types.HealthAlive, false},
+		{false, 1 * time.Second, types.HealthAlive, false},
+		{false, 2 * time.Second, types.HealthSuspect, true},
+		{false, 5 * time.Second, types.HealthSuspect, false},
+		{true, 6 * time.Second, types.HealthAlive, true},
+		{false, 7 * time.Second, types.HealthAlive, false},
+		{false, 8 * time.Second, types.HealthSuspect, true},
+		{false, 18 * time.Second, types.HealthDead, true},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("Observe_%d", i), func(t *testing.T) {
+			ev := Observe(cfg, sl, c.ok, start.Add(c.after))
+			if sl.State != c.state || (ev != nil) != c.event {
+				t.FailNow()
+			}
+			if ev != nil && (ev.To != c.state || ev.Addr != sl.RAddr) {
+				t.FailNow()
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/audit"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	//ErrNoRevision revision of config not found
+	ErrNoRevision = errors.New("no revision of config")
+	//ErrConflict config has moved on from the expected revision
+	ErrConflict = errors.New("config revision conflict")
+)
+
+//Hash hex encoded sha256 of config body
+func Hash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+//Head latest revision number of config key, zero if config has no revision
+func Head(st store.Store, key string) (uint64, error) {
+	return uintKey(st, fmt.Sprintf(types.RevisionHeadFormat, key))
+}
+
+//Current global revision of the store, zero if nothing has been committed
+func Current(st store.Store) (uint64, error) {
+	return uintKey(st, types.GlobalRevisionKey)
+}
+
+//Commit record body as a new revision of config key and make it current,
+//it fails with ErrConflict if expect is given and the config has moved on.
+//entry, unless nil, is completed with the revision and appended to the audit log in the same commit
+func Commit(st store.Store, key string, body string, author string, entry *types.AuditEntry, expect ...*types.Precondition) (*types.ConfigRevision, error) {
+	return commit(st, key, body, false, author, entry, expect...)
+}
+
+//Delete record a tombstone revision of config key and remove its current value,
+//it fails with ErrNoRevision if the config does not exist. entry is recorded as by Commit
+func Delete(st store.Store, key string, author string, entry *types.AuditEntry, expect ...*types.Precondition) (*types.ConfigRevision, error) {
+	return commit(st, key, "", true, author, entry, expect...)
+}
+
+func commit(st store.Store, key string, body string, deleted bool, author string, entry *types.AuditEntry, expect ...*types.Precondition) (*types.ConfigRevision, error) {
+	// commits on a store are serialized so that config revisions, global revisions and
+	// changelog entries are allocated in the same order
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	headKey := []byte(fmt.Sprintf(types.RevisionHeadFormat, key))
+
+	var rev *types.ConfigRevision
+	for {
+		headBytes, err := st.Get(headKey)
+		if err == store.ErrNotFound {
+			headBytes = nil
+		} else if err != nil {
+			return nil, err
+		}
+		var head uint64
+		if headBytes != nil {
+			head, err = strconv.ParseUint(string(headBytes), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(expect) != 0 && expect[0] != nil {
+			err = check(st, key, head, expect[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if deleted {
+			_, err := st.Get([]byte(key))
+			if err == store.ErrNotFound {
+				return nil, ErrNoRevision
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		rev = &types.ConfigRevision{
+			Revision:  head + 1,
+			Timestamp: time.Now().Unix(),
+			Author:    author,
+			Hash:      Hash(body),
+			Body:      body,
+			Deleted:   deleted,
+		}
+		// the head may still move under writers sharing the store from outside this process
+		ok, err := st.CompareAndSwap(headKey, headBytes, []byte(strconv.FormatUint(rev.Revision, 10)))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if len(expect) != 0 && expect[0] != nil {
+			return nil, ErrConflict
+		}
+	}
+
+	revBytes, err := json.Marshal(rev)
+	if err != nil {
+		return nil, err
+	}
+	err = st.Put([]byte(fmt.Sprintf(types.RevisionFormat, key, rev.Revision)), revBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if rev.Deleted {
+		err = st.Delete([]byte(key))
+	} else {
+		err = st.Put([]byte(key), []byte(rev.Body))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil {
+		err = record(st, key, rev, entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rev, appendChange(st, key, rev, entry)
+}
+
+//record complete entry with rev of config key and append it to the audit log
+func record(st store.Store, key string, rev *types.ConfigRevision, entry *types.AuditEntry) error {
+	entry.Timestamp = rev.Timestamp
+	entry.Name, entry.Tag = types.ParseConfigKey(key)
+	entry.Revision = rev.Revision
+	if !rev.Deleted {
+		entry.Hash = rev.Hash
+	}
+	if rev.Revision > 1 {
+		prev, err := Revision(st, key, rev.Revision-1)
+		if err != nil {
+			return err
+		}
+		if !prev.Deleted {
+			entry.PrevHash = prev.Hash
+		}
+	}
+	return audit.Append(st, entry)
+}
+
+//appendChange allocate the next global revision, set it as rev's Global and record the change
+//and its audit entry in the changelog, entries older than types.ChangelogRetention are compacted
+func appendChange(st store.Store, key string, rev *types.ConfigRevision, entry *types.AuditEntry) error {
+	current, err := Current(st)
+	if err != nil {
+		return err
+	}
+	term, err := uintKey(st, types.RaftTermKey)
+	if err != nil {
+		return err
+	}
+
+	rev.Global = current + 1
+	meta := *rev
+	meta.Body = ""
+	name, tag := types.ParseConfigKey(key)
+	return writeChange(st, &types.ConfigChange{
+		Revision: current + 1,
+		Term:     term,
+		Name:     name,
+		Tag:      tag,
+		Body:     rev.Body,
+		Deleted:  rev.Deleted,
+		History:  &meta,
+		Audit:    entry,
+	})
+}
+
+//writeChange write change to the changelog and make its revision the global revision
+func writeChange(st store.Store, change *types.ConfigChange) error {
+	changeBytes, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	err = st.Put([]byte(fmt.Sprintf(types.ChangelogFormat, change.Revision)), changeBytes)
+	if err != nil {
+		return err
+	}
+	err = setCurrent(st, change.Revision, change.Term)
+	if err != nil {
+		return err
+	}
+
+	if change.Revision > types.ChangelogRetention {
+		return st.Delete([]byte(fmt.Sprintf(types.ChangelogFormat, change.Revision-types.ChangelogRetention)))
+	}
+	return nil
+}
+
+func setCurrent(st store.Store, revision uint64, term uint64) error {
+	err := st.Put([]byte(types.GlobalRevisionKey), []byte(strconv.FormatUint(revision, 10)))
+	if err != nil {
+		return err
+	}
+	return st.Put([]byte(types.LastTermKey), []byte(strconv.FormatUint(term, 10)))
+}
+
+//Replay apply a change received from master, the changelog, global revision and
+//config history are kept in step so the node can serve deltas or take over as master.
+//the caller holds st.CommitLock()
+func Replay(st store.Store, change *types.ConfigChange) error {
+	key := types.ConfigKey(change.Name, change.Tag)
+	var err error
+	if change.Deleted {
+		err = st.Delete([]byte(key))
+	} else {
+		err = st.Put([]byte(key), []byte(change.Body))
+	}
+	if err != nil {
+		return err
+	}
+
+	if change.History != nil {
+		rev := *change.History
+		rev.Body = change.Body
+		revBytes, err := json.Marshal(&rev)
+		if err != nil {
+			return err
+		}
+		err = st.Put([]byte(fmt.Sprintf(types.RevisionFormat, key, rev.Revision)), revBytes)
+		if err != nil {
+			return err
+		}
+		err = st.Put([]byte(fmt.Sprintf(types.RevisionHeadFormat, key)), []byte(strconv.FormatUint(rev.Revision, 10)))
+		if err != nil {
+			return err
+		}
+	}
+
+	if change.Audit != nil {
+		// the entry takes the next sequence number of this node's log
+		entry := *change.Audit
+		err = audit.Append(st, &entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	if change.Revision == 0 {
+		return nil
+	}
+	return writeChange(st, change)
+}
+
+//Restore reset the global revision after a snapshot has been replayed,
+//the changelog no longer matches the store and is dropped. the caller holds st.CommitLock()
+func Restore(st store.Store, revision uint64, term uint64) error {
+	pairs, err := st.Items("changelog/")
+	if err != nil {
+		return err
+	}
+	for _, kv := range pairs {
+		err = st.Delete(kv.Key)
+		if err != nil {
+			return err
+		}
+	}
+	return setCurrent(st, revision, term)
+}
+
+//LastTerm raft term of the global revision
+func LastTerm(st store.Store) (uint64, error) {
+	return uintKey(st, types.LastTermKey)
+}
+
+//Consistent whether a node at revision of term shares this store's history,
+//a node which does not must be sent a snapshot
+func Consistent(st store.Store, revision uint64, term uint64) (bool, error) {
+	if revision == 0 {
+		return true, nil
+	}
+	current, err := Current(st)
+	if err != nil {
+		return false, err
+	}
+	if revision > current {
+		return false, nil
+	}
+	if revision == current {
+		last, err := LastTerm(st)
+		if err != nil {
+			return false, err
+		}
+		return last == term, nil
+	}
+
+	v, err := st.Get([]byte(fmt.Sprintf(types.ChangelogFormat, revision)))
+	if err == store.ErrNotFound {
+		// compacted, an incremental sync falls back to snapshot anyway
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var change types.ConfigChange
+	err = json.Unmarshal(v, &change)
+	if err != nil {
+		return false, err
+	}
+	return change.Term == term, nil
+}
+
+//uintKey number stored at key, zero if key doesn't exist
+func uintKey(st store.Store, key string) (uint64, error) {
+	v, err := st.Get([]byte(key))
+	if err == store.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(v), 10, 64)
+}
+
+//Changes changelog entries after revision from in ascending order,
+//ok is false if some of them have been compacted
+func Changes(st store.Store, from uint64) (changes []*types.ConfigChange, current uint64, ok bool, err error) {
+	current, err = Current(st)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if from > current || current-from > types.ChangelogRetention {
+		return nil, current, false, nil
+	}
+
+	changes = make([]*types.ConfigChange, 0, current-from)
+	for r := from + 1; r <= current; r++ {
+		v, err := st.Get([]byte(fmt.Sprintf(types.ChangelogFormat, r)))
+		if err == store.ErrNotFound {
+			return nil, current, false, nil
+		}
+		if err != nil {
+			return nil, current, false, err
+		}
+		var change types.ConfigChange
+		err = json.Unmarshal(v, &change)
+		if err != nil {
+			return nil, current, false, err
+		}
+		changes = append(changes, &change)
+	}
+	return changes, current, true, nil
+}
+
+//check compare head revision of config key with precondition
+func check(st store.Store, key string, head uint64, expect *types.Precondition) error {
+	if expect.Revision != nil && *expect.Revision != head {
+		return ErrConflict
+	}
+	if expect.Hash != "" {
+		if head == 0 {
+			return ErrConflict
+		}
+		cur, err := Revision(st, key, head)
+		if err == ErrNoRevision {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+		if cur.Hash != expect.Hash {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+//Revision get revision of config key
+func Revision(st store.Store, key string, revision uint64) (*types.ConfigRevision, error) {
+	v, err := st.Get([]byte(fmt.Sprintf(types.RevisionFormat, key, revision)))
+	if err == store.ErrNotFound {
+		return nil, ErrNoRevision
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rev types.ConfigRevision
+	err = json.Unmarshal(v, &rev)
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+//Revisions list all revisions of config key in ascending order
+func Revisions(st store.Store, key string) ([]*types.ConfigRevision, error) {
+	pairs, err := st.Items(fmt.Sprintf("revision/%s/", key))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*types.ConfigRevision, 0, len(pairs))
+	for _, kv := range pairs {
+		var rev types.ConfigRevision
+		err = json.Unmarshal(kv.Value, &rev)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &rev)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Revision < out[j].Revision
+	})
+	return out, nil
+}
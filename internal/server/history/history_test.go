@@ -0,0 +1,265 @@
+package history
+
+import (
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"sync"
+	"testing"
+)
+
+func TestCommit(t *testing.T) {
+	s := store.NewMapStore()
+	bodies := []string{"version: 1", "version: 2", "version: 3"}
+
+	for i, body := range bodies {
+		t.Run(fmt.Sprintf("Commit_%d", i), func(t *testing.T) {
+			rev, err := Commit(s, "config/app/#dev", body, "tester", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev.Revision != uint64(i+1) || rev.Hash != Hash(body) {
+				t.FailNow()
+			}
+			v, _ := s.Get([]byte("config/app/#dev"))
+			if string(v) != body {
+				t.FailNow()
+			}
+		})
+	}
+
+	t.Run("Revisions", func(t *testing.T) {
+		revs, err := Revisions(s, "config/app/#dev")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revs) != len(bodies) {
+			t.FailNow()
+		}
+		for i, rev := range revs {
+			if rev.Revision != uint64(i+1) || rev.Body != bodies[i] {
+				t.FailNow()
+			}
+		}
+	})
+
+	t.Run("Revision_NoItem", func(t *testing.T) {
+		_, err := Revision(s, "config/app/#dev", 10)
+		if err != ErrNoRevision {
+			t.FailNow()
+		}
+	})
+}
+
+func TestCommit_Precondition(t *testing.T) {
+	s := store.NewMapStore()
+	zero := uint64(0)
+	one := uint64(1)
+
+	t.Run("Commit_ExpectAbsent", func(t *testing.T) {
+		_, err := Commit(s, "config/app/#dev", "version: 1", "tester", nil, &types.Precondition{Revision: &zero})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Commit(s, "config/app/#dev", "version: 1", "tester", nil, &types.Precondition{Revision: &zero})
+		if err != ErrConflict {
+			t.FailNow()
+		}
+	})
+	t.Run("Commit_ExpectRevision", func(t *testing.T) {
+		_, err := Commit(s, "config/app/#dev", "version: 2", "tester", nil, &types.Precondition{Revision: &one})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = Commit(s, "config/app/#dev", "version: 3", "tester", nil, &types.Precondition{Revision: &one})
+		if err != ErrConflict {
+			t.FailNow()
+		}
+	})
+	t.Run("Commit_ExpectHash", func(t *testing.T) {
+		_, err := Commit(s, "config/app/#dev", "version: 3", "tester", nil, &types.Precondition{Hash: Hash("version: 1")})
+		if err != ErrConflict {
+			t.FailNow()
+		}
+		_, err = Commit(s, "config/app/#dev", "version: 3", "tester", nil, &types.Precondition{Hash: Hash("version: 2")})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestCommit_Concurrent(t *testing.T) {
+	s := store.NewMapStore()
+	g := sync.WaitGroup{}
+	globals := make([]uint64, 20)
+	for i := 0; i < 20; i++ {
+		g.Add(1)
+		go func(i int) {
+			defer g.Done()
+			rev, err := Commit(s, "config/app/#dev", fmt.Sprintf("version: %d", i), "tester", nil)
+			if err == nil {
+				globals[i] = rev.Global
+			}
+		}(i)
+	}
+	g.Wait()
+
+	// every commit knows its own global revision
+	seen := make(map[uint64]bool)
+	for _, global := range globals {
+		if global == 0 || global > 20 || seen[global] {
+			t.FailNow()
+		}
+		seen[global] = true
+	}
+
+	head, _ := Head(s, "config/app/#dev")
+	if head != 20 {
+		t.FailNow()
+	}
+	latest, _ := Revision(s, "config/app/#dev", head)
+	v, _ := s.Get([]byte("config/app/#dev"))
+	if string(v) != latest.Body {
+		t.FailNow()
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s := store.NewMapStore()
+
+	t.Run("Delete_NoItem", func(t *testing.T) {
+		_, err := Delete(s, "config/app/#dev", "tester", nil)
+		if err != ErrNoRevision {
+			t.FailNow()
+		}
+	})
+	t.Run("Delete_Tombstone", func(t *testing.T) {
+		_, _ = Commit(s, "config/app/#dev", "version: 1", "tester", nil)
+		rev, err := Delete(s, "config/app/#dev", "tester", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev.Revision != 2 || !rev.Deleted {
+			t.FailNow()
+		}
+		if _, err := s.Get([]byte("config/app/#dev")); err == nil {
+			t.FailNow()
+		}
+	})
+	t.Run("Delete_Recommit", func(t *testing.T) {
+		rev, _ := Commit(s, "config/app/#dev", "version: 3", "tester", nil)
+		v, err := s.Get([]byte("config/app/#dev"))
+		if err != nil || string(v) != "version: 3" || rev.Revision != 3 {
+			t.FailNow()
+		}
+	})
+}
+
+func TestChanges(t *testing.T) {
+	s := store.NewMapStore()
+	_, _ = Commit(s, "config/app/#dev", "version: 1", "tester", nil)
+	_, _ = Commit(s, "config/web/#dev", "version: 1", "tester", nil)
+	_, _ = Delete(s, "config/app/#dev", "tester", nil)
+
+	t.Run("Changes_All", func(t *testing.T) {
+		changes, current, ok, err := Changes(s, 0)
+		if err != nil || !ok || current != 3 || len(changes) != 3 {
+			t.FailNow()
+		}
+		if changes[2].Name != "app" || !changes[2].Deleted || changes[2].Revision != 3 {
+			t.FailNow()
+		}
+	})
+	t.Run("Changes_Since", func(t *testing.T) {
+		changes, _, ok, _ := Changes(s, 2)
+		if !ok || len(changes) != 1 {
+			t.FailNow()
+		}
+	})
+	t.Run("Changes_Ahead", func(t *testing.T) {
+		_, _, ok, _ := Changes(s, 10)
+		if ok {
+			t.FailNow()
+		}
+	})
+}
+
+func TestCommit_Audit(t *testing.T) {
+	s := store.NewMapStore()
+	key := types.ConfigKey("app", []string{"dev"})
+	zero := uint64(0)
+	tests := []struct {
+		body     string
+		deleted  bool
+		audited  bool
+		expect   *types.Precondition
+		recorded bool
+		prevHash string
+		hash     string
+	}{
+		{"version: 1", false, true, nil, true, "", Hash("version: 1")},
+		{"version: 2", false, false, nil, false, "", ""},
+		{"version: 3", false, true, nil, true, Hash("version: 2"), Hash("version: 3")},
+		{"", true, true, nil, true, Hash("version: 3"), ""},
+		{"version: 4", false, true, nil, true, "", Hash("version: 4")},
+		// a failed write records nothing
+		{"version: 5", false, true, &types.Precondition{Revision: &zero}, false, "", ""},
+	}
+	var seq uint64
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("CommitAudit_%d", i), func(t *testing.T) {
+			entry := &types.AuditEntry{Op: types.AuditPush, Author: "tester"}
+			audited := entry
+			if !test.audited {
+				audited = nil
+			}
+			var rev *types.ConfigRevision
+			var err error
+			if test.deleted {
+				rev, err = Delete(s, key, "tester", audited, test.expect)
+			} else {
+				rev, err = Commit(s, key, test.body, "tester", audited, test.expect)
+			}
+			if (err == nil) != (rev != nil) {
+				t.FailNow()
+			}
+			if !test.recorded {
+				if entry.Seq != 0 {
+					t.FailNow()
+				}
+				return
+			}
+			seq++
+			if err != nil || entry.Seq != seq || entry.Revision != rev.Revision || entry.Name != "app" || entry.Timestamp != rev.Timestamp ||
+				entry.PrevHash != test.prevHash || entry.Hash != test.hash {
+				t.FailNow()
+			}
+		})
+	}
+
+	t.Run("CommitAudit_Replay", func(t *testing.T) {
+		changes, _, ok, err := Changes(s, 0)
+		if err != nil || !ok {
+			t.FailNow()
+		}
+		replica := store.NewMapStore()
+		for _, c := range changes {
+			if err := Replay(replica, c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for seq := 1; seq <= 4; seq++ {
+			want, err := s.Get([]byte(fmt.Sprintf(types.AuditFormat, seq)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := replica.Get([]byte(fmt.Sprintf(types.AuditFormat, seq)))
+			if err != nil || string(got) != string(want) {
+				t.FailNow()
+			}
+		}
+		if _, err := replica.Get([]byte(fmt.Sprintf(types.AuditFormat, 5))); err != store.ErrNotFound {
+			t.FailNow()
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
+	"github.com/Jarnpher553/gonfig/internal/server/replication"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
+	"github.com/Jarnpher553/gonfig/internal/util/retry"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//linkState state of a slave's link to master
+type linkState string
+
+const (
+	//linkOnline slave is registered and heartbeats succeed
+	linkOnline linkState = "Online"
+	//linkOrphaned master is reachable but no longer knows the slave
+	linkOrphaned linkState = "Orphaned"
+	//linkDisconnected master is unreachable
+	linkDisconnected linkState = "Disconnected"
+)
+
+const (
+	heartbeatInterval = 5 * time.Second
+	rejoinMinBackoff  = 1 * time.Second
+	rejoinMaxBackoff  = 30 * time.Second
+)
+
+var errUnknownSlave = errors.New("slave unknown to master")
+
+//keepAlive heartbeat master until stop is closed, a slave which lost the master
+//re-registers and catches up with backoff
+func (s *Server) keepAlive(state linkState, stop <-chan struct{}) {
+	t := time.NewTicker(heartbeatInterval)
+	defer t.Stop()
+	for {
+		if state != linkOnline {
+			err := retry.Backoff(s.rejoin, rejoinMinBackoff, rejoinMaxBackoff, stop)
+			if err != nil {
+				return
+			}
+			state = s.transit(state, linkOnline)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		err := s.heartbeat()
+		if err == errUnknownSlave {
+			state = s.transit(state, linkOrphaned)
+		} else if err != nil {
+			s.logger.Info("Heartbeat master:[%s] failure: %s", color.Green(s.masterAddr), err)
+			state = s.transit(state, linkDisconnected)
+		}
+	}
+}
+
+func (s *Server) transit(from linkState, to linkState) linkState {
+	if from != to {
+		s.logger.Info("Slave state [%s] -> [%s] master:[%s]", color.Green(from), color.Green(to), color.Green(s.masterAddr))
+	}
+	return to
+}
+
+//rejoin register to master again and catch up with the changes missed meanwhile
+func (s *Server) rejoin() error {
+	err := s.register()
+	if err != nil {
+		s.logger.Info("Register slave: %s", err)
+		return err
+	}
+	err = s.catchUp()
+	if err != nil {
+		s.logger.Info("Catch up with master: %s", err)
+	}
+	return err
+}
+
+//heartbeat tell master the slave is alive, it catches up if master has moved on
+func (s *Server) heartbeat() error {
+	url := s.peer.URL(s.masterAddr, "/heartbeat")
+
+	applied, _, err := replication.Applied(s.store)
+	if err != nil {
+		return err
+	}
+	version, err := credential.Version(s.store)
+	if err != nil {
+		return err
+	}
+	self := &types.SlaveMetaReq{
+		ID:          s.meta.ID,
+		Addr:        s.meta.RAddr,
+		Role:        string(s.meta.Role),
+		Revision:    applied,
+		RPCAddr:     s.meta.RPCAddr,
+		Credentials: version,
+	}
+	jsonBytes, err := json.Marshal(self)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.peer.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errUnknownSlave
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("heartbeat failure")
+	}
+
+	var hb types.HeartbeatResp
+	err = json.NewDecoder(resp.Body).Decode(&hb)
+	if err != nil {
+		return err
+	}
+	if hb.Revision != applied {
+		return s.catchUp()
+	}
+	return nil
+}
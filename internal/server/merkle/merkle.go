@@ -0,0 +1,90 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"sort"
+)
+
+//Buckets leaves of the hash tree, config keys are spread over them by key hash
+const Buckets = 256
+
+//Bucket leaf of the hash tree holding config key
+func Bucket(key string) int {
+	sum := sha256.Sum256([]byte(key))
+	return int(sum[0]) % Buckets
+}
+
+//Digest hash tree of the store's config keyspace, every leaf hashes the keys
+//and body hashes of one bucket and every inner node hashes its two children
+func Digest(st store.Store) (*types.Digest, error) {
+	revision, err := history.Current(st)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := st.Items("config/")
+	if err != nil {
+		return nil, err
+	}
+
+	leaves := make([][]string, Buckets)
+	for _, kv := range pairs {
+		key := string(kv.Key)
+		b := Bucket(key)
+		leaves[b] = append(leaves[b], key+"\x00"+history.Hash(string(kv.Value)))
+	}
+
+	nodes := make([]string, 2*Buckets-1)
+	for i, entries := range leaves {
+		if len(entries) == 0 {
+			continue
+		}
+		sort.Strings(entries)
+		h := sha256.New()
+		for _, e := range entries {
+			h.Write([]byte(e))
+			h.Write([]byte{'\n'})
+		}
+		nodes[Buckets-1+i] = hex.EncodeToString(h.Sum(nil))
+	}
+	for i := Buckets - 2; i >= 0; i-- {
+		left, right := nodes[2*i+1], nodes[2*i+2]
+		if left == "" && right == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(left + right))
+		nodes[i] = hex.EncodeToString(sum[:])
+	}
+
+	return &types.Digest{Revision: revision, Nodes: nodes}, nil
+}
+
+//Diff buckets whose leaves differ, found by descending from the root
+//into the subtrees whose hashes differ
+func Diff(a *types.Digest, b *types.Digest) []int {
+	buckets := make([]int, 0)
+	if len(a.Nodes) != len(b.Nodes) {
+		for i := 0; i < Buckets; i++ {
+			buckets = append(buckets, i)
+		}
+		return buckets
+	}
+
+	var walk func(i int)
+	walk = func(i int) {
+		if a.Nodes[i] == b.Nodes[i] {
+			return
+		}
+		if i >= Buckets-1 {
+			buckets = append(buckets, i-(Buckets-1))
+			return
+		}
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+	return buckets
+}
@@ -0,0 +1,42 @@
+package merkle
+
+import (
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	a := store.NewMapStore()
+	b := store.NewMapStore()
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("config/app%d/#dev", i)
+		_, _ = history.Commit(a, key, "version: 1", "tester", nil)
+		_, _ = history.Commit(b, key, "version: 1", "tester", nil)
+	}
+
+	t.Run("Digest_Equal", func(t *testing.T) {
+		da, _ := Digest(a)
+		db, _ := Digest(b)
+		if da.Nodes[0] == "" || da.Nodes[0] != db.Nodes[0] || len(Diff(da, db)) != 0 {
+			t.FailNow()
+		}
+	})
+	t.Run("Digest_Differ", func(t *testing.T) {
+		_ = b.Put([]byte("config/app7/#dev"), []byte("drift"))
+		_ = b.Delete([]byte("config/app9/#dev"))
+		da, _ := Digest(a)
+		db, _ := Digest(b)
+		buckets := Diff(da, db)
+		want := map[int]bool{Bucket("config/app7/#dev"): true, Bucket("config/app9/#dev"): true}
+		if len(buckets) != len(want) {
+			t.FailNow()
+		}
+		for _, bucket := range buckets {
+			if !want[bucket] {
+				t.FailNow()
+			}
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/server/route"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
+	"github.com/satori/go.uuid"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ErrBodyTooLarge request body is larger than the limit of BodyLimit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+//Middleware wraps the handler of a http route, it is called once per route when the route is registered
+type Middleware func(rt *route.Router, next http.Handler) http.Handler
+
+//Func middleware which wraps every route alike
+func Func(f func(next http.Handler) http.Handler) Middleware {
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		return f(next)
+	}
+}
+
+//Chain wrap h of route rt with mws, the first middleware is the outermost one
+func Chain(rt *route.Router, h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](rt, h)
+	}
+	return h
+}
+
+//Error respond to a failed request with the json error envelope
+func Error(w http.ResponseWriter, r *http.Request, status int, message string) {
+	respBytes, _ := json.Marshal(types.ErrorResp{Code: types.ErrorCodeOf(status), Message: message, RequestID: types.RequestID(r.Context())})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(respBytes)
+}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+//RequestID assign the request an id kept in its context and returned in the X-Request-ID response
+//header, a valid id in the request header is reused. requests forwarded to master carry the same id
+func RequestID() Middleware {
+	return Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(types.RequestIDHeader)
+			if !requestIDPattern.MatchString(id) {
+				id = uuid.NewV4().String()
+				r.Header.Set(types.RequestIDHeader, id)
+			}
+			w.Header().Set(types.RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(types.WithRequestID(r.Context(), id)))
+		})
+	})
+}
+
+//Logging log every request with its status, response size and latency, requests between nodes
+//are logged at debug level
+func Logging(log logger.Logger) Middleware {
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		logf := log.Info
+		if rt.Access == types.AccessReplica {
+			logf = log.Debug
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := recorded(w)
+			next.ServeHTTP(rec, r)
+			logf("Request [%s %s] id:[%s] from:[%s] status:[%s] size:[%s] latency:[%s]", color.Green(r.Method), color.Green(r.URL.Path), color.Green(types.RequestID(r.Context())), color.Green(r.RemoteAddr), color.Green(rec.Status()), color.Green(rec.size), color.Green(time.Since(start)))
+		})
+	}
+}
+
+//Metrics record the status and latency of every request in m, keyed by the route
+func Metrics(m *types.Metrics) Middleware {
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := recorded(w)
+			next.ServeHTTP(rec, r)
+			m.Observe(rt.URL, rec.Status(), time.Since(start))
+		})
+	}
+}
+
+//Recovery log a panicking handler with its stack and respond 500 if nothing has been written yet
+func Recovery(log logger.Logger) Middleware {
+	return Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := recorded(w)
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// the reverse proxy aborts a response it can't finish, net/http closes the connection
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				log.Error("Request [%s] id:[%s] panic: %v\n%s", color.Green(r.URL.Path), color.Green(types.RequestID(r.Context())), err, debug.Stack())
+				if rec.status == 0 {
+					Error(rec, r, http.StatusInternalServerError, "internal error")
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	})
+}
+
+//CORS allow browsers on origins to call the routes, "*" allows any origin. preflight requests are
+//answered here. it does nothing if origins is empty
+func CORS(origins []string) Middleware {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		if len(allowed) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(allowed["*"] || allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Expose-Headers", strings.Join([]string{types.RequestIDHeader, types.LeaderHeader}, ", "))
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", rt.Method)
+				h.Set("Access-Control-Allow-Headers", strings.Join([]string{"Authorization", "Content-Type", types.RequestIDHeader}, ", "))
+				h.Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//Method respond 405 to requests whose method isn't the route's
+func Method() Middleware {
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != rt.Method {
+				w.Header().Set("Allow", rt.Method)
+				Error(w, r, http.StatusMethodNotAllowed, "method not allowed, use "+rt.Method)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//BodyLimit respond 413 to request bodies larger than n bytes, reading a body past the limit fails
+//with ErrBodyTooLarge. the routes between nodes, which carry snapshots, are not limited
+func BodyLimit(n int64) Middleware {
+	return func(rt *route.Router, next http.Handler) http.Handler {
+		if n <= 0 || rt.Access == types.AccessReplica {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				Error(w, r, http.StatusRequestEntityTooLarge, "request body larger than "+strconv.FormatInt(n, 10)+" bytes")
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: n}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//limitedBody request body failing with ErrBodyTooLarge once more than remaining bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	// read one byte past the limit to tell a body of exactly the limit from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		return int(b.remaining), ErrBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+//recorder response writer remembering the status and size of the response
+type recorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+//recorded recorder of w, the middlewares of a route share the outermost one
+func recorded(w http.ResponseWriter) *recorder {
+	if rec, ok := w.(*recorder); ok {
+		return rec
+	}
+	return &recorder{ResponseWriter: w}
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+//Flush flush the underlying writer if it supports it, for streamed responses
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Status status of the response, 200 if the handler wrote nothing
+func (rec *recorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
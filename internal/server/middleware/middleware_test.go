@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	"github.com/Jarnpher553/gonfig/internal/server/route"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	alog "github.com/lesismal/arpc/log"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	order := make([]string, 0)
+	mark := func(name string) Middleware {
+		return Func(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+	rt := route.NewRouter(http.MethodGet, "/cluster", types.AccessReader)
+	h := Chain(rt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), mark("a"), mark("b"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cluster", nil))
+	if strings.Join(order, ",") != "a,b,handler" {
+		t.FailNow()
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	log := &logger.XLogger{}
+	log.SetLevel(alog.LevelNone)
+	metrics := types.NewMetrics()
+	mws := []Middleware{RequestID(), Logging(log), Metrics(metrics), Recovery(log), CORS([]string{"https://a.example"}), Method(), BodyLimit(16)}
+	rt := route.NewRouter(http.MethodPost, "/push", types.AccessWriter)
+	h := Chain(rt, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err == ErrBodyTooLarge {
+			Error(w, r, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if string(body) == "panic" {
+			panic("handler panic")
+		}
+		w.WriteHeader(http.StatusOK)
+	}), mws...)
+
+	tests := []struct {
+		method string
+		body   string
+		header map[string]string
+		status int
+		check  func(resp *http.Response) bool
+	}{
+		{http.MethodPost, "{}", nil, http.StatusOK, func(resp *http.Response) bool {
+			return resp.Header.Get(types.RequestIDHeader) != ""
+		}},
+		{http.MethodPost, "{}", map[string]string{types.RequestIDHeader: "req-1"}, http.StatusOK, func(resp *http.Response) bool {
+			return resp.Header.Get(types.RequestIDHeader) == "req-1"
+		}},
+		{http.MethodPost, "panic", map[string]string{types.RequestIDHeader: "req-2"}, http.StatusInternalServerError, func(resp *http.Response) bool {
+			var e types.ErrorResp
+			return json.NewDecoder(resp.Body).Decode(&e) == nil && e.Code == types.CodeInternal && e.RequestID == "req-2"
+		}},
+		{http.MethodGet, "", nil, http.StatusMethodNotAllowed, func(resp *http.Response) bool {
+			return resp.Header.Get("Allow") == http.MethodPost
+		}},
+		{http.MethodPost, strings.Repeat("x", 17), nil, http.StatusRequestEntityTooLarge, nil},
+		{http.MethodOptions, "", map[string]string{"Origin": "https://a.example", "Access-Control-Request-Method": http.MethodPost}, http.StatusNoContent, func(resp *http.Response) bool {
+			return resp.Header.Get("Access-Control-Allow-Origin") == "https://a.example" && resp.Header.Get("Access-Control-Allow-Methods") == http.MethodPost
+		}},
+		{http.MethodPost, "{}", map[string]string{"Origin": "https://b.example"}, http.StatusOK, func(resp *http.Response) bool {
+			return resp.Header.Get("Access-Control-Allow-Origin") == ""
+		}},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Middleware_%d", i), func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "/push", strings.NewReader(test.body))
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			resp := w.Result()
+			if resp.StatusCode != test.status || (test.check != nil && !test.check(resp)) {
+				t.FailNow()
+			}
+		})
+	}
+
+	t.Run("Middleware_Chunked", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/push", strings.NewReader(strings.Repeat("x", 17)))
+		req.ContentLength = -1
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.FailNow()
+		}
+	})
+	t.Run("Middleware_Metrics", func(t *testing.T) {
+		var buf strings.Builder
+		if _, err := metrics.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+		if !strings.Contains(out, `gonfig_http_requests_total{path="/push",code="500"} 1`) ||
+			!strings.Contains(out, `gonfig_http_request_duration_seconds_count{path="/push"} 8`) {
+			t.FailNow()
+		}
+	})
+}
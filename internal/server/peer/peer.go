@@ -0,0 +1,75 @@
+package peer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//TokenHeader request header carrying the cluster token of the node which forwarded a request,
+//the request keeps the client's Authorization header
+const TokenHeader = "X-Gonfig-Peer-Token"
+
+//Timeout max duration of a request between nodes, reading the response body included
+const Timeout = 30 * time.Second
+
+//Client http client used between nodes of a cluster
+type Client struct {
+	*http.Client
+	scheme string
+}
+
+//New construct Client, requests are sent over https with tlsCfg when it is set and
+//carry token, the cluster token, unless it is empty. requests time out after Timeout
+func New(tlsCfg *tls.Config, token string) *Client {
+	var transport http.RoundTripper
+	scheme := "http"
+	if tlsCfg != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsCfg.Clone()
+		transport, scheme = t, "https"
+	}
+	if token != "" {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		transport = &tokenTransport{RoundTripper: transport, token: token}
+	}
+	return &Client{Client: &http.Client{Transport: transport, Timeout: Timeout}, scheme: scheme}
+}
+
+//tokenTransport authorize requests with the cluster token, forwarded requests keep the
+//credential of the client and carry the token in TokenHeader
+type tokenTransport struct {
+	http.RoundTripper
+	token string
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	} else {
+		req.Header.Set(TokenHeader, t.token)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+//Scheme url scheme of the nodes, http or https
+func (c *Client) Scheme() string {
+	return c.scheme
+}
+
+//URL url of path on the node at addr
+func (c *Client) URL(addr string, path string) string {
+	return fmt.Sprintf("%s://%s%s", c.scheme, addr, path)
+}
+
+//RoundTripper transport of the client, it is never nil
+func (c *Client) RoundTripper() http.RoundTripper {
+	if c.Transport == nil {
+		return http.DefaultTransport
+	}
+	return c.Transport
+}
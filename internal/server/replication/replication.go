@@ -0,0 +1,256 @@
+package replication
+
+import (
+	"errors"
+	"github.com/Jarnpher553/gonfig/internal/server/event"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/merkle"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+)
+
+//ErrOutOfOrder sync changes are based on a revision the slave has not applied yet
+var ErrOutOfOrder = errors.New("sync changes out of order")
+
+//ErrStaleRepair repair was read at another revision than the slave has applied
+var ErrStaleRepair = errors.New("repair based on another revision")
+
+//For sync request bringing a slave at revision of term up to date,
+//a slave whose history diverged from this node's is sent a snapshot
+func For(st store.Store, revision uint64, term uint64) (*types.SyncConfigReq, error) {
+	ok, err := history.Consistent(st, revision, term)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return Snapshot(st)
+	}
+	return Delta(st, revision)
+}
+
+//Delta sync request bringing a slave at revision from up to date,
+//it falls back to a full snapshot if the changelog no longer covers from
+func Delta(st store.Store, from uint64) (*types.SyncConfigReq, error) {
+	changes, current, ok, err := history.Changes(st, from)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return Snapshot(st)
+	}
+
+	return &types.SyncConfigReq{
+		From:     from,
+		Revision: current,
+		Datum:    changes,
+	}, nil
+}
+
+//Snapshot sync request carrying the full config set and the latest revision of each config
+func Snapshot(st store.Store) (*types.SyncConfigReq, error) {
+	current, err := history.Current(st)
+	if err != nil {
+		return nil, err
+	}
+	term, err := history.LastTerm(st)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := st.Items("config/")
+	if err != nil {
+		return nil, err
+	}
+
+	req := &types.SyncConfigReq{
+		Revision: current,
+		LastTerm: term,
+		Snapshot: true,
+		Datum:    make([]*types.ConfigChange, 0, len(pairs)),
+	}
+	for _, kv := range pairs {
+		req.Datum = append(req.Datum, latest(st, string(kv.Key), string(kv.Value)))
+	}
+	return req, nil
+}
+
+//Range anti-entropy repair request carrying the configs held in buckets
+func Range(st store.Store, buckets []int) (*types.RepairReq, error) {
+	// the configs are read at the revision sent along, no commit lands in between
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	current, err := history.Current(st)
+	if err != nil {
+		return nil, err
+	}
+	want := inBuckets(buckets)
+	pairs, err := st.Items("config/")
+	if err != nil {
+		return nil, err
+	}
+
+	req := &types.RepairReq{Revision: current, Buckets: buckets, Datum: make([]*types.ConfigChange, 0)}
+	for _, kv := range pairs {
+		if want(string(kv.Key)) {
+			req.Datum = append(req.Datum, latest(st, string(kv.Key), string(kv.Value)))
+		}
+	}
+	return req, nil
+}
+
+//latest change carrying the config's value and its latest revision
+func latest(st store.Store, key string, value string) *types.ConfigChange {
+	name, tag := types.ParseConfigKey(key)
+	change := &types.ConfigChange{Name: name, Tag: tag, Body: value}
+	if head, err := history.Head(st, key); err == nil && head != 0 {
+		if rev, err := history.Revision(st, key, head); err == nil {
+			rev.Body = ""
+			change.History = rev
+		}
+	}
+	return change
+}
+
+func inBuckets(buckets []int) func(key string) bool {
+	set := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		set[b] = true
+	}
+	return func(key string) bool {
+		return set[merkle.Bucket(key)]
+	}
+}
+
+//Applied last global revision applied by the slave and its raft term
+func Applied(st store.Store) (uint64, uint64, error) {
+	revision, err := history.Current(st)
+	if err != nil {
+		return 0, 0, err
+	}
+	term, err := history.LastTerm(st)
+	if err != nil {
+		return 0, 0, err
+	}
+	return revision, term, nil
+}
+
+//Apply apply sync request to the slave's store and return the changes that
+//actually modified it, changes the slave has already applied are skipped
+func Apply(st store.Store, req *types.SyncConfigReq) ([]*types.ConfigChange, error) {
+	// sync requests may arrive concurrently, they are applied one by one and not
+	// in between the steps of a commit
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	applied, err := history.Current(st)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Snapshot {
+		changes, err := diff(st, req.Datum, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			err = history.Replay(st, c)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return changes, history.Restore(st, req.Revision, req.LastTerm)
+	}
+
+	if req.From > applied {
+		return nil, ErrOutOfOrder
+	}
+	changes := make([]*types.ConfigChange, 0, len(req.Datum))
+	for _, c := range req.Datum {
+		if c.Revision <= applied {
+			continue
+		}
+		err = history.Replay(st, c)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+//Repair replace the slave's configs in the request's buckets with master's,
+//it returns the changes that actually modified the store. it fails with ErrStaleRepair
+//unless the slave has applied the request's revision and nothing later
+func Repair(st store.Store, req *types.RepairReq) ([]*types.ConfigChange, error) {
+	// sync requests may arrive concurrently, they are applied one by one and not
+	// in between the steps of a commit
+	mux := st.CommitLock()
+	mux.Lock()
+	defer mux.Unlock()
+
+	applied, err := history.Current(st)
+	if err != nil {
+		return nil, err
+	}
+	if applied != req.Revision {
+		return nil, ErrStaleRepair
+	}
+
+	changes, err := diff(st, req.Datum, inBuckets(req.Buckets))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range changes {
+		err = history.Replay(st, c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+//diff changes turning the slave's configs into the snapshot, only keys accepted
+//by within are compared if it is not nil
+func diff(st store.Store, snapshot []*types.ConfigChange, within func(key string) bool) ([]*types.ConfigChange, error) {
+	pairs, err := st.Items("config/")
+	if err != nil {
+		return nil, err
+	}
+	local := make(map[string]string, len(pairs))
+	for _, kv := range pairs {
+		if within == nil || within(string(kv.Key)) {
+			local[string(kv.Key)] = string(kv.Value)
+		}
+	}
+
+	changes := make([]*types.ConfigChange, 0)
+	for _, c := range snapshot {
+		key := types.ConfigKey(c.Name, c.Tag)
+		if v, ok := local[key]; !ok || v != c.Body {
+			changes = append(changes, c)
+		}
+		delete(local, key)
+	}
+	for key := range local {
+		name, tag := types.ParseConfigKey(key)
+		changes = append(changes, &types.ConfigChange{Name: name, Tag: tag, Deleted: true})
+	}
+	return changes, nil
+}
+
+//Events publish events of applied changes, deletions are published on the config's tombstone topic
+func Events(changes []*types.ConfigChange) []*event.Event {
+	events := make([]*event.Event, 0, len(changes))
+	for _, c := range changes {
+		cfgName := types.ConfigKey(c.Name, c.Tag)
+		if c.Deleted {
+			events = append(events, &event.Event{Type: event.PubConfig, Body: map[string]interface{}{"cfgName": types.TombstoneKey(cfgName), "cfgMeta": cfgName}})
+		} else {
+			events = append(events, &event.Event{Type: event.PubConfig, Body: map[string]interface{}{"cfgName": cfgName, "cfgMeta": c.Body}})
+		}
+	}
+	return events
+}
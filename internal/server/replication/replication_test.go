@@ -0,0 +1,137 @@
+package replication
+
+import (
+	"github.com/Jarnpher553/gonfig/internal/server/history"
+	"github.com/Jarnpher553/gonfig/internal/server/merkle"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	master := store.NewMapStore()
+	slave := store.NewMapStore()
+	_, _ = history.Commit(master, "config/app/#dev", "version: 1", "tester", nil)
+	_, _ = history.Commit(master, "config/web/#dev", "version: 1", "tester", nil)
+
+	t.Run("Apply_Delta", func(t *testing.T) {
+		req, _ := Delta(master, 0)
+		if req.Snapshot || len(req.Datum) != 2 {
+			t.FailNow()
+		}
+		changes, err := Apply(slave, req)
+		if err != nil || len(changes) != 2 {
+			t.FailNow()
+		}
+		applied, _, _ := Applied(slave)
+		if applied != 2 {
+			t.FailNow()
+		}
+	})
+	t.Run("Apply_OutOfOrder", func(t *testing.T) {
+		_, _ = history.Delete(master, "config/app/#dev", "tester", nil)
+		req, _ := Delta(master, 3)
+		req.From = 3
+		_, err := Apply(slave, req)
+		if err != ErrOutOfOrder {
+			t.FailNow()
+		}
+	})
+	t.Run("Apply_Delete", func(t *testing.T) {
+		req, _ := Delta(master, 2)
+		changes, err := Apply(slave, req)
+		if err != nil || len(changes) != 1 || !changes[0].Deleted {
+			t.FailNow()
+		}
+		if _, err := slave.Get([]byte("config/app/#dev")); err == nil {
+			t.FailNow()
+		}
+	})
+	t.Run("Apply_Snapshot", func(t *testing.T) {
+		_ = slave.Put([]byte("config/stale/#dev"), []byte("stale"))
+		req, _ := Snapshot(master)
+		changes, err := Apply(slave, req)
+		if err != nil || len(changes) != 1 || !changes[0].Deleted {
+			t.FailNow()
+		}
+		if _, err := slave.Get([]byte("config/stale/#dev")); err == nil {
+			t.FailNow()
+		}
+	})
+}
+
+func TestFor(t *testing.T) {
+	leader := store.NewMapStore()
+	follower := store.NewMapStore()
+	_, _ = history.Commit(leader, "config/app/#dev", "version: 1", "tester", nil)
+	req, _ := For(leader, 0, 0)
+	_, _ = Apply(follower, req)
+
+	t.Run("For_Consistent", func(t *testing.T) {
+		_, _ = history.Commit(leader, "config/app/#dev", "version: 2", "tester", nil)
+		revision, term, _ := Applied(follower)
+		req, _ := For(leader, revision, term)
+		if req.Snapshot || len(req.Datum) != 1 {
+			t.FailNow()
+		}
+		_, _ = Apply(follower, req)
+	})
+	t.Run("For_Diverged", func(t *testing.T) {
+		// follower committed on its own in a later term
+		_ = follower.Put([]byte(types.RaftTermKey), []byte("2"))
+		_, _ = history.Commit(follower, "config/app/#dev", "version: 3", "tester", nil)
+		_, _ = history.Commit(leader, "config/app/#dev", "version: 4", "leader", nil)
+		revision, term, _ := Applied(follower)
+		req, _ := For(leader, revision, term)
+		if !req.Snapshot {
+			t.FailNow()
+		}
+		_, err := Apply(follower, req)
+		if err != nil {
+			t.FailNow()
+		}
+		revision, term, _ = Applied(follower)
+		rev, _ := history.Revision(follower, "config/app/#dev", 3)
+		if revision != 3 || term != 0 || rev == nil || rev.Author != "leader" {
+			t.FailNow()
+		}
+	})
+}
+
+func TestRepair(t *testing.T) {
+	master := store.NewMapStore()
+	slave := store.NewMapStore()
+	_, _ = history.Commit(master, "config/app/#dev", "version: 1", "tester", nil)
+	_, _ = history.Commit(master, "config/web/#dev", "version: 1", "tester", nil)
+	req, _ := Delta(master, 0)
+	_, _ = Apply(slave, req)
+
+	_ = slave.Put([]byte("config/app/#dev"), []byte("drift"))
+	_ = slave.Put([]byte("config/stale/#dev"), []byte("stale"))
+	buckets := []int{merkle.Bucket("config/app/#dev"), merkle.Bucket("config/stale/#dev")}
+
+	repair, _ := Range(master, buckets)
+	if repair.Revision != 2 {
+		t.FailNow()
+	}
+	stale := *repair
+	stale.Revision = 1
+	if _, err := Repair(slave, &stale); err != ErrStaleRepair {
+		t.FailNow()
+	}
+	changes, err := Repair(slave, repair)
+	if err != nil || len(changes) != 2 {
+		t.FailNow()
+	}
+	if v, _ := slave.Get([]byte("config/app/#dev")); string(v) != "version: 1" {
+		t.FailNow()
+	}
+	if _, err := slave.Get([]byte("config/stale/#dev")); err == nil {
+		t.FailNow()
+	}
+	dm, _ := merkle.Digest(master)
+	ds, _ := merkle.Digest(slave)
+	if dm.Nodes[0] != ds.Nodes[0] {
+		t.FailNow()
+	}
+}
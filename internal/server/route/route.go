@@ -1,13 +1,18 @@
 package route
 
+import "github.com/Jarnpher553/gonfig/internal/server/types"
+
 type Router struct {
 	URL    string
 	Method string
+	//Access role a credential needs on the route when the http api requires tokens
+	Access types.Access
 }
 
-func NewRouter(method, url string) *Router {
+func NewRouter(method, url string, access types.Access) *Router {
 	return &Router{
 		URL:    url,
 		Method: method,
+		Access: access,
 	}
 }
@@ -1,16 +1,26 @@
 package rpchandler
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
 	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/util/color"
 	"github.com/lesismal/arpc"
 	"strings"
 )
 
+var (
+	//ErrUnauthenticated client has not authenticated
+	ErrUnauthenticated = errors.New("unauthenticated")
+	//ErrDenied client's credential isn't granted the config
+	ErrDenied = errors.New("access denied")
+)
+
 //RpcHandlerFunc rpc handler function type
 type RpcHandlerFunc func(s *types.ServiceCtx) func(c *arpc.Context)
 
-//EchoHandler client echo self information handler
+//EchoHandler client echo self information handler, the client's credential must be granted the config
 func EchoHandler(ctx *types.ServiceCtx) func(c *arpc.Context) {
 	return func(c *arpc.Context) {
 
@@ -18,6 +28,17 @@ func EchoHandler(ctx *types.ServiceCtx) func(c *arpc.Context) {
 		if err := c.Bind(&in); err != nil {
 			return
 		}
+		app, ok := App(c.Client)
+		if !ok {
+			ctx.Logger.Warn("Echo config name:[%s] tag:[%s] from:[%s] denied, unauthenticated", color.Green(in.Name), color.Green(in.Tags), color.Green(c.Client.Conn.RemoteAddr()))
+			c.Error(ErrUnauthenticated)
+			return
+		}
+		if !credential.Allowed(ctx.Store, app, in.Name, in.Tags) {
+			ctx.Logger.Warn("Echo config name:[%s] tag:[%s] app:[%s] from:[%s] denied", color.Green(in.Name), color.Green(in.Tags), color.Green(app), color.Green(c.Client.Conn.RemoteAddr()))
+			c.Error(ErrDenied)
+			return
+		}
 		cfgName := fmt.Sprintf(types.ConfigFormat, in.Name, strings.Join(in.Tags, "#"))
 		v, err := ctx.Store.Get([]byte(cfgName))
 		if err != nil {
@@ -28,3 +49,14 @@ func EchoHandler(ctx *types.ServiceCtx) func(c *arpc.Context) {
 		c.Write(v)
 	}
 }
+
+//App application the rpc client authenticated as, empty for the shared password,
+//ok is false if the client has not authenticated
+func App(c *arpc.Client) (string, bool) {
+	v, ok := c.Get(types.AppKey)
+	if !ok {
+		return "", false
+	}
+	app, _ := v.(string)
+	return app, true
+}
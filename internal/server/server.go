@@ -2,37 +2,46 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Jarnpher553/gonfig/internal/logger"
-	"github.com/Jarnpher553/gonfig/internal/server/cmdflag"
+	"github.com/Jarnpher553/gonfig/internal/server/certs"
+	"github.com/Jarnpher553/gonfig/internal/server/consensus"
+	"github.com/Jarnpher553/gonfig/internal/server/credential"
 	"github.com/Jarnpher553/gonfig/internal/server/event"
 	"github.com/Jarnpher553/gonfig/internal/server/handler"
+	"github.com/Jarnpher553/gonfig/internal/server/health"
 	"github.com/Jarnpher553/gonfig/internal/server/listener"
+	"github.com/Jarnpher553/gonfig/internal/server/middleware"
+	"github.com/Jarnpher553/gonfig/internal/server/peer"
+	"github.com/Jarnpher553/gonfig/internal/server/replication"
 	"github.com/Jarnpher553/gonfig/internal/server/route"
 	"github.com/Jarnpher553/gonfig/internal/server/rpchandler"
 	"github.com/Jarnpher553/gonfig/internal/server/types"
 	"github.com/Jarnpher553/gonfig/internal/store"
+	"github.com/Jarnpher553/gonfig/internal/util/addr"
 	"github.com/Jarnpher553/gonfig/internal/util/color"
 	"github.com/Jarnpher553/gonfig/internal/util/retry"
 	"github.com/common-nighthawk/go-figure"
+	"github.com/lesismal/arpc"
 	"github.com/lesismal/arpc/extension/pubsub"
 	alog "github.com/lesismal/arpc/log"
 	"github.com/satori/go.uuid"
+	"io"
+	"net"
 	"net/http"
-	"os"
-	"os/signal"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
 type eventHandler func(map[string]interface{}) error
 
+//certsInterval interval the certificate files are checked for changes
+const certsInterval = 10 * time.Second
+
 //Server gonfig server
 type Server struct {
 	meta          *types.ServerMetadata
@@ -43,203 +52,459 @@ type Server struct {
 	store         store.Store
 	listener      *listener.Listener
 	rpcListener   *listener.Listener
+	rpcAddr       string
 	masterAddr    string
 	serverMux     *http.ServeMux
 	httpRouters   []*route.Router
+	middlewares   []middleware.Middleware
+	metrics       *types.Metrics
 	rpcRouters    []string
 	trigger       event.Trigger
 	eventHandlers map[string]eventHandler
-	logger        *logger.XLogger
-	rpcLogger     *logger.XLogger
+	logger        logger.Logger
+	rpcLogger     logger.Logger
+	status        *types.Status
+	consensus     *consensus.Node
+	acks          *types.Acks
+	//running keys of the background runs, true if one more run was requested, guarded by mux
+	running map[string]bool
+	//antiEntropyStatus guarded by mux
+	antiEntropyStatus *types.AntiEntropyStatus
+	chain             *types.Chain
+	relay             bool
+	healthCfg         *types.HealthCfg
+	checker           health.Checker
+	//healthEvents guarded by mux
+	healthEvents *[]*types.HealthEvent
+	tls          *tls.Config
+	mutualTLS    bool
+	certs        *certs.Reloader
+	peer         *peer.Client
+	auth         *authCoder
+	token        string
+	stop         chan struct{}
+	//rpcDone closed once the rpc server stopped serving
+	rpcDone chan struct{}
+	//closer closes the store opened by New at shutdown, nil for a store of Options
+	closer io.Closer
+}
+
+//Options dependencies of Server, nil fields are replaced by defaults
+type Options struct {
+	//Store persists configs, a leveldb store in the working directory is opened if nil and closed
+	//by Shutdown. a given store is left open
+	Store store.Store
+	//Logger logs the http server and the cluster, the rpc server too when RpcLogger is nil
+	Logger logger.Logger
+	//RpcLogger logs the rpc server, it is set as arpc's process wide logger
+	RpcLogger logger.Logger
+	//Checker probes the health of slaves
+	Checker health.Checker
+	//TLS serves both listeners over tls, requests between nodes use it as client config.
+	//nodes must present a certificate to each other if it has ClientCAs
+	TLS *tls.Config
+	//Certs certificate files reloaded while the server runs, it takes precedence over TLS
+	Certs *certs.Reloader
+	//Middleware wraps every http route in order, after request ids, logging, metrics, recovery,
+	//cors, the method check and body limits, and before authentication
+	Middleware []middleware.Middleware
 }
 
 //New construct Server
-func New(args ...interface{}) *Server {
-	logx := &logger.XLogger{}
-
-	var cfg *types.ServerCfg
-	var persist store.Store
-	for _, arg := range args {
-		switch v := arg.(type) {
-		case *types.ServerCfg:
-			if v != nil {
-				cfg = v
-			}
-		case store.Store:
-			if v != nil {
-				persist = v
-			}
-		}
-	}
+func New(cfg *types.ServerCfg, opts *Options) (_ *Server, err error) {
 	if cfg == nil {
-		cfg = cmdflag.Parse(logx)
+		return nil, errors.New("server config is nil")
+	}
+	if opts == nil {
+		opts = &Options{}
 	}
+
+	persist := opts.Store
+	var closer io.Closer
 	if persist == nil {
-		leveldbStore, err := store.NewLeveldbStore(store.StorageFile)
+		var leveldbStore *store.LeveldbStore
+		leveldbStore, err = store.NewLeveldbStore(store.StorageFile)
 		if err != nil {
-			logx.Fatal("Leveldb open: %s", err)
+			return nil, fmt.Errorf("leveldb open: %s", err)
+		}
+		persist, closer = leveldbStore, leveldbStore
+		// the store is only kept by a server New returns
+		defer func() {
+			if err != nil {
+				_ = leveldbStore.Close()
+			}
+		}()
+	}
+	// nodes couldn't authenticate to each other, and the http api would be open to anyone
+	if cfg.Token == "" && !credential.Empty(persist) {
+		return nil, errors.New("credentials are stored but no cluster token is set")
+	}
+	tlsCfg, mutual := opts.TLS, false
+	if opts.Certs != nil {
+		tlsCfg, mutual = opts.Certs.Config(), opts.Certs.Mutual()
+	} else if tlsCfg != nil && tlsCfg.ClientCAs != nil {
+		tlsCfg, mutual = tlsCfg.Clone(), true
+		if tlsCfg.ClientAuth == tls.NoClientCert {
+			tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
 		}
-		persist = leveldbStore
 	}
+	pc := peer.New(tlsCfg, cfg.Token)
+	checker := opts.Checker
+	if checker == nil {
+		checker = health.HTTPChecker{Client: pc}
+	}
+	healthCfg := cfg.Health
+	if healthCfg == nil {
+		healthCfg = types.DefaultHealthCfg()
+	}
+	events := make([]*types.HealthEvent, 0)
 
-	logx.SetLevel(alog.LevelInfo)
-	logx.SetModName("HttpServer")
+	var logx logger.Logger = opts.Logger
+	if logx == nil {
+		xl := &logger.XLogger{}
+		xl.SetLevel(alog.LevelInfo)
+		xl.SetModName("HttpServer")
+		logx = xl
+	}
 
-	start := strings.Index(cfg.Addr, ":")
+	_, port, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("server address: %s", err)
+	}
+	rpcListen, rpcAdvertised, err := addr.RPC(cfg.Addr, cfg.RPCAddr)
+	if err != nil {
+		return nil, fmt.Errorf("rpc address: %s", err)
+	}
 	s := &Server{
 		meta: &types.ServerMetadata{
-			ID:    uuid.NewV4(),
-			Role:  cfg.Role,
-			RAddr: cfg.Addr,
-			LAddr: cfg.Addr[start:],
+			ID:      uuid.NewV4(),
+			Role:    cfg.Role,
+			RAddr:   cfg.Addr,
+			LAddr:   net.JoinHostPort("", port),
+			RPCAddr: rpcAdvertised,
 		},
-		store:       persist,
-		trigger:     make(chan *event.Event, 5),
-		logger:      logx,
-		httpRouters: make([]*route.Router, 0),
-		rpcRouters:  make([]string, 0),
+		rpcAddr:           rpcListen,
+		store:             persist,
+		status:            &types.Status{},
+		acks:              types.NewAcks(),
+		antiEntropyStatus: &types.AntiEntropyStatus{},
+		chain:             types.NewChain(cfg.Addr),
+		healthCfg:         healthCfg,
+		checker:           checker,
+		healthEvents:      &events,
+		tls:               tlsCfg,
+		mutualTLS:         mutual,
+		certs:             opts.Certs,
+		peer:              pc,
+		token:             cfg.Token,
+		stop:              make(chan struct{}),
+		closer:            closer,
+		trigger:           make(chan *event.Event, 5),
+		running:           make(map[string]bool),
+		logger:            logx,
+		httpRouters:       make([]*route.Router, 0),
+		metrics:           types.NewMetrics(),
+		rpcRouters:        make([]string, 0),
 	}
 	s.eventHandlers = map[string]eventHandler{
-		event.SyncConfig: s.eventSyncHandler,
-		event.PubConfig:  s.eventPubHandler,
+		event.SyncConfig:       s.eventSyncHandler,
+		event.PubConfig:        s.eventPubHandler,
+		event.SlaveHealth:      s.eventHealthHandler,
+		event.SyncCredentials:  s.eventSyncCredentialsHandler,
+		event.RevokeCredential: s.eventRevokeHandler,
 	}
 
-	if s.meta.Role == types.RoleMaster {
+	if cfg.Mode == types.ModeRaft {
+		s.mux = &sync.Mutex{}
+		slaves := make([]*types.ServerMetadata, 0)
+		s.slaves = &slaves
+		s.consensus = consensus.New(&consensus.Config{
+			ID:          s.meta.ID,
+			Addr:        s.meta.RAddr,
+			RPCAddr:     s.meta.RPCAddr,
+			Peers:       cfg.Peers,
+			Store:       s.store,
+			Logger:      logx,
+			Client:      pc,
+			Status:      s.status,
+			OnLeader:    s.onLeader,
+			OnFollower:  s.onFollower,
+			OnHeartbeat: s.onHeartbeat,
+			Clients:     s.clients,
+		})
+	} else if s.meta.Role == types.RoleMaster {
 		s.mux = &sync.Mutex{}
 		slaves := make([]*types.ServerMetadata, 0)
 		s.slaves = &slaves
+		s.status.SetReady(true)
 	} else if s.meta.Role == types.RoleSlave {
 		s.masterAddr = cfg.MasterAddr
+		if cfg.Relay {
+			s.relay = true
+			s.mux = &sync.Mutex{}
+			slaves := make([]*types.ServerMetadata, 0)
+			s.slaves = &slaves
+		}
 	}
 
-	logx2 := &logger.XLogger{}
-	logx2.SetLevel(alog.LevelInfo)
+	var logx2 logger.Logger = opts.RpcLogger
+	if logx2 == nil {
+		logx2 = opts.Logger
+	}
+	if logx2 == nil {
+		xl := &logger.XLogger{}
+		xl.SetLevel(alog.LevelInfo)
+		logx2 = xl
+	}
 	alog.SetLogger(logx2)
 	s.rpcLogger = logx2
 	psServer := pubsub.NewServer()
-	psServer.Password = types.PubSubPassword
 	psServer.Handler.SetLogTag("[" + color.Green("RpcServer") + "]")
 	s.psServer = psServer
+	s.auth = newAuthCoder(s, cfg.Password)
+	psServer.Password = s.auth.internal
+	psServer.Handler.UseCoder(s.auth)
 
 	s.rpcRoute("/echo", rpchandler.EchoHandler)
 
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = 30 * time.Second
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = 30 * time.Second
+	}
 	serverMux := http.NewServeMux()
 	s.httpServer = &http.Server{
 		Addr:           s.meta.LAddr,
 		Handler:        serverMux,
-		ReadTimeout:    30 * time.Second,
-		WriteTimeout:   30 * time.Second,
+		ReadTimeout:    cfg.ReadTimeout,
+		WriteTimeout:   cfg.WriteTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
 	s.serverMux = serverMux
 
-	if s.meta.Role == types.RoleMaster {
-		s.httpRoute(route.NewRouter(http.MethodPost, "/register"), handler.RegisterSlaveHandler)
-		s.httpRoute(route.NewRouter(http.MethodPost, "/unregister"), handler.UnregisterSlaveHandler)
-		s.httpRoute(route.NewRouter(http.MethodPost, "/push"), handler.PushConfigHandler)
+	maxBody := cfg.MaxBodyBytes
+	if maxBody == 0 {
+		maxBody = types.DefaultMaxBodyBytes
+	}
+	s.middlewares = []middleware.Middleware{
+		middleware.RequestID(),
+		middleware.Logging(logx),
+		middleware.Metrics(s.metrics),
+		middleware.Recovery(logx),
+		middleware.CORS(cfg.CORSOrigins),
+		middleware.Method(),
+		middleware.BodyLimit(maxBody),
+	}
+	s.middlewares = append(s.middlewares, opts.Middleware...)
+	s.middlewares = append(s.middlewares, handler.Authorize(s.serviceCtx()))
+
+	if s.consensus != nil {
+		s.httpRoute(route.NewRouter(http.MethodPost, "/raft/vote", types.AccessReplica), handler.Peer(handler.VoteHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/raft/append", types.AccessReplica), handler.Peer(handler.AppendHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/changes", types.AccessReplica), handler.Peer(handler.ChangesHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/sync", types.AccessReplica), handler.Peer(handler.SyncConfigurationHandler))
+		s.httpRoute(route.NewRouter(http.MethodGet, "/health", types.AccessReplica), handler.Peer(handler.HealthHandler))
+		s.httpRoute(route.NewRouter(http.MethodGet, "/digest", types.AccessReplica), handler.Peer(handler.DigestHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/repair", types.AccessReplica), handler.Peer(handler.RepairHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/credentials/sync", types.AccessReplica), handler.Peer(handler.SyncCredentialsHandler))
+	} else if s.meta.Role == types.RoleMaster {
+		s.httpRoute(route.NewRouter(http.MethodPost, "/register", types.AccessReplica), handler.Peer(handler.RegisterSlaveHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/unregister", types.AccessReplica), handler.Peer(handler.UnregisterSlaveHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/heartbeat", types.AccessReplica), handler.Peer(handler.HeartbeatHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/changes", types.AccessReplica), handler.Peer(handler.ChangesHandler))
 	} else if s.meta.Role == types.RoleSlave {
-		s.httpRoute(route.NewRouter(http.MethodPost, "/sync"), handler.SyncConfigurationHandler)
-		s.httpRoute(route.NewRouter(http.MethodGet, "/health"), handler.HealthHandler)
+		s.httpRoute(route.NewRouter(http.MethodPost, "/sync", types.AccessReplica), handler.Peer(handler.SyncConfigurationHandler))
+		s.httpRoute(route.NewRouter(http.MethodGet, "/health", types.AccessReplica), handler.Peer(handler.HealthHandler))
+		s.httpRoute(route.NewRouter(http.MethodGet, "/digest", types.AccessReplica), handler.Peer(handler.DigestHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/repair", types.AccessReplica), handler.Peer(handler.RepairHandler))
+		s.httpRoute(route.NewRouter(http.MethodPost, "/credentials/sync", types.AccessReplica), handler.Peer(handler.SyncCredentialsHandler))
+		if s.relay {
+			s.httpRoute(route.NewRouter(http.MethodPost, "/register", types.AccessReplica), handler.Peer(handler.RegisterSlaveHandler))
+			s.httpRoute(route.NewRouter(http.MethodPost, "/unregister", types.AccessReplica), handler.Peer(handler.UnregisterSlaveHandler))
+			s.httpRoute(route.NewRouter(http.MethodPost, "/heartbeat", types.AccessReplica), handler.Peer(handler.HeartbeatHandler))
+			s.httpRoute(route.NewRouter(http.MethodPost, "/changes", types.AccessReplica), handler.Peer(handler.ChangesHandler))
+		}
 	}
 
-	s.httpRoute(route.NewRouter(http.MethodPost, "/pull"), handler.PullConfigHandler)
+	// writes and cluster status are routed on every node, nodes other than master forward them to master
+	s.httpRoute(route.NewRouter(http.MethodPost, "/push", types.AccessWriter), handler.Forward(handler.PushConfigHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/rollback", types.AccessWriter), handler.Forward(handler.RollbackHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/delete", types.AccessWriter), handler.Forward(handler.DeleteConfigHandler))
+	s.httpRoute(route.NewRouter(http.MethodGet, "/cluster", types.AccessReader), handler.Forward(handler.ClusterHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/credentials/create", types.AccessAdmin), handler.Forward(handler.CreateCredentialHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/credentials/grant", types.AccessAdmin), handler.Forward(handler.GrantHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/credentials/revoke", types.AccessAdmin), handler.Forward(handler.RevokeCredentialHandler))
+	s.httpRoute(route.NewRouter(http.MethodGet, "/credentials", types.AccessAdmin), handler.Forward(handler.CredentialsHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/audit", types.AccessAdmin), handler.Forward(handler.AuditHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/audit/export", types.AccessAdmin), handler.Forward(handler.ExportAuditHandler))
+	s.httpRoute(route.NewRouter(http.MethodPost, "/pull", types.AccessReader), handler.PullConfigHandler)
+	s.httpRoute(route.NewRouter(http.MethodPost, "/revisions", types.AccessReader), handler.RevisionsHandler)
+	s.httpRoute(route.NewRouter(http.MethodPost, "/list", types.AccessReader), handler.ListConfigHandler)
+	s.httpRoute(route.NewRouter(http.MethodGet, "/metrics", types.AccessReader), handler.MetricsHandler)
+
+	return s, nil
+}
+
+func (s *Server) serviceCtx() *types.ServiceCtx {
+	ctx := &types.ServiceCtx{
+		Meta:         s.meta,
+		Slaves:       s.slaves,
+		Store:        s.store,
+		Mux:          s.mux,
+		Trigger:      s.trigger,
+		Logger:       s.logger,
+		Status:       s.status,
+		Master:       s.masterAddr,
+		Acks:         s.acks,
+		Clients:      s.clients,
+		Authenticate: s.auth.verify,
+		Token:        s.token,
+		Metrics:      s.metrics,
+		AntiEntropy:  s.antiEntropyStatus,
+		HealthEvents: s.healthEvents,
+		Chain:        s.chain,
+		Peer:         s.peer,
+		MutualTLS:    s.mutualTLS,
+	}
+	if s.consensus != nil {
+		ctx.Consensus = s.consensus
+	}
+	return ctx
+}
 
-	return s
+//clients count of connected rpc clients
+func (s *Server) clients() int {
+	count := 0
+	s.psServer.ForEach(func(*arpc.Client) {
+		count++
+	})
+	return count
 }
 
 func (s *Server) rpcRoute(methodName string, handlerFunc rpchandler.RpcHandlerFunc) {
 	s.rpcRouters = append(s.rpcRouters, methodName)
-	serviceCtx := &types.ServiceCtx{
-		Meta:    s.meta,
-		Slaves:  s.slaves,
-		Store:   s.store,
-		Mux:     s.mux,
-		Trigger: s.trigger,
-		Logger:  s.logger,
-	}
-	s.psServer.Handler.Handle(methodName, handlerFunc(serviceCtx))
+	s.psServer.Handler.Handle(methodName, handlerFunc(s.serviceCtx()))
 }
 
 func (s *Server) httpRoute(r *route.Router, handlerFunc handler.HandlerFunc) {
 	s.httpRouters = append(s.httpRouters, r)
-	serviceCtx := &types.ServiceCtx{
-		Meta:    s.meta,
-		Slaves:  s.slaves,
-		Store:   s.store,
-		Mux:     s.mux,
-		Trigger: s.trigger,
-		Logger:  s.logger,
-	}
-	s.serverMux.HandleFunc(r.URL, s.recovery(handlerFunc(serviceCtx, r.Method)))
-}
-
-func (s *Server) recovery(f func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				s.logger.Error("Recover: %s", err)
-			}
-		}()
-		f(w, r)
-	}
+	s.serverMux.Handle(r.URL, middleware.Chain(r, http.HandlerFunc(handlerFunc(s.serviceCtx(), r.Method)), s.middlewares...))
 }
 
 func (s *Server) printRoutes() {
 	for i, r := range s.httpRouters {
-		s.logger.Info("HttpRouter [%s] Method [%s] Path [%s]", color.Green(i), color.Green(r.Method), color.Green(r.URL))
+		s.logger.Info("HttpRouter [%s] Method [%s] Path [%s] Role [%s]", color.Green(i), color.Green(r.Method), color.Green(r.URL), color.Green(r.Access))
 	}
 	for i, v := range s.rpcRouters {
 		s.rpcLogger.Info("%s RpcRouter [%s] Method [%s]", s.psServer.Handler.LogTag(), color.Green(i), color.Green(v))
 	}
 }
 
-//Serve run server
-func (s *Server) Serve() {
+//Start listen and start replicating, it returns once the server is serving,
+//ctx bounds the initial catch up of a slave with its master
+func (s *Server) Start(ctx context.Context) error {
 	ln, err := listener.New(s.meta.LAddr)
 	if err != nil {
-		s.logger.Fatal("Create listener: %s", err)
+		return fmt.Errorf("create listener: %s", err)
 	}
 	s.listener = ln
 
+	rpcLn, err := listener.New(s.rpcAddr)
+	if err != nil {
+		_ = ln.Close()
+		return fmt.Errorf("create rpc listener: %s", err)
+	}
+	s.rpcListener = rpcLn
+
 	figure.NewColorFigure(string(s.meta.Role), "", "green", true).Print()
 	s.printRoutes()
 
+	var httpLn net.Listener = ln
+	var psLn net.Listener = rpcLn
+	if s.tls != nil {
+		httpLn = tls.NewListener(ln, s.tls)
+		psLn = tls.NewListener(rpcLn, s.tls)
+	}
+
 	s.logger.Info("[%s]/[%s] listening on [%s]", color.Green(s.meta.Role), color.Green(s.meta.ID), color.Green(s.meta.LAddr))
 	go func() {
-		if err := s.httpServer.Serve(ln); err != nil {
-			s.logger.Info("Listen: %s", err)
+		if err := s.httpServer.Serve(httpLn); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Listen: %s", err)
 		}
 	}()
-
-	rpcPort, _ := strconv.Atoi(strings.Split(s.meta.LAddr, ":")[1])
-	rpcLn, err := listener.New(fmt.Sprintf(":%d", rpcPort+1))
-	if err != nil {
-		s.logger.Fatal("Create rpc listener: %s", err)
-	}
-	s.rpcListener = rpcLn
+	s.rpcDone = make(chan struct{})
 	go func() {
-		if err := s.psServer.Serve(rpcLn); err != nil {
+		defer close(s.rpcDone)
+		if err := s.psServer.Serve(psLn); err != nil {
 			s.rpcLogger.Info("%s Listen: %s", s.psServer.Handler.LogTag(), err)
 		}
 	}()
 
-	if s.meta.Role == types.RoleSlave {
+	stop := s.stop
+	if s.certs != nil {
+		go s.certs.Watch(certsInterval, stop, s.logger)
+	}
+	if s.consensus != nil {
+		go s.execEvent(stop)
+		go s.consensus.Run(stop)
+		go s.antiEntropy(stop)
+	} else if s.meta.Role == types.RoleSlave {
+		go s.execEvent(stop)
+		state := linkOnline
 		err := s.register()
 		if err != nil {
-			s.logger.Fatal("Register slave: %s", err)
+			s.logger.Error("Register slave: %s", err)
+			state = linkDisconnected
+		} else {
+			err = s.initialCatchUp(ctx)
+			if err != nil {
+				s.logger.Error("Catch up with master: %s", err)
+				state = linkDisconnected
+			}
+		}
+		go s.keepAlive(state, stop)
+		if s.relay {
+			s.loadSlaves()
+			go s.healthCheck(stop)
+			go s.antiEntropy(stop)
 		}
 	} else {
 		s.loadSlaves()
-		go s.execEvent()
-		go s.healthCheck()
+		go s.execEvent(stop)
+		go s.healthCheck(stop)
+		go s.antiEntropy(stop)
 	}
+	return nil
+}
 
-	notify := make(chan os.Signal)
-	signal.Notify(notify, syscall.SIGINT, syscall.SIGTERM)
+//initialCatchUp catch up with master at start, it gives up after a few attempts or once ctx is done
+func (s *Server) initialCatchUp(ctx context.Context) error {
+	var err error
+	for i := 0; i < 5; i++ {
+		if err = s.catchUp(); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return err
+}
 
-	<-notify
+//Shutdown stop replicating, unregister a slave from its master, gracefully close both listeners and
+//close the store opened by New, ctx bounds the whole shutdown
+func (s *Server) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.stop:
+		return errors.New("server is already shut down")
+	default:
+		close(s.stop)
+	}
 
-	if s.meta.Role == types.RoleSlave {
+	if s.consensus == nil && s.meta.Role == types.RoleSlave {
 		err := s.unregister()
 		if err != nil {
 			s.logger.Info("Unregister slave: %s", err)
@@ -247,50 +512,165 @@ func (s *Server) Serve() {
 	}
 
 	s.logger.Info("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := s.httpServer.Shutdown(ctx); err != nil {
-		s.logger.Fatal("Forced to shutdown: %s", err)
+	httpErr := s.httpServer.Shutdown(ctx)
+	if httpErr != nil {
+		s.logger.Error("Forced to shutdown: %s", httpErr)
 	}
 
 	s.rpcLogger.Info("%s Shutting down server...", s.psServer.Handler.LogTag())
-	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel2()
-	if err := s.psServer.Shutdown(ctx2); err != nil {
-		s.rpcLogger.Fatal("%s Forced to shutdown: %s", s.psServer.Handler.LogTag(), err)
+	// arpc's Server.Shutdown races with its accept loop, which ends and disconnects the clients
+	// once the listener is closed as well
+	var rpcErr error
+	if s.rpcListener != nil {
+		_ = s.rpcListener.Close()
+		select {
+		case <-s.rpcDone:
+		case <-ctx.Done():
+			rpcErr = ctx.Err()
+		}
+	}
+	if rpcErr != nil {
+		s.rpcLogger.Error("%s Forced to shutdown: %s", s.psServer.Handler.LogTag(), rpcErr)
+	}
+
+	var storeErr error
+	if s.closer != nil {
+		storeErr = s.closer.Close()
 	}
 
 	s.logger.Info("Server exiting")
 	s.rpcLogger.Info("%s Server exiting", s.psServer.Handler.LogTag())
+	if httpErr != nil {
+		return fmt.Errorf("shutdown http server: %s", httpErr)
+	}
+	if rpcErr != nil {
+		return fmt.Errorf("shutdown rpc server: %s", rpcErr)
+	}
+	if storeErr != nil {
+		return fmt.Errorf("close store: %s", storeErr)
+	}
+	return nil
 }
 
 func (s *Server) register() error {
-	url := fmt.Sprintf("http://%s/register", s.masterAddr)
+	url := s.peer.URL(s.masterAddr, "/register")
 
+	applied, _, err := replication.Applied(s.store)
+	if err != nil {
+		return err
+	}
+	version, err := credential.Version(s.store)
+	if err != nil {
+		return err
+	}
 	self := &types.SlaveMetaReq{
-		ID:   s.meta.ID,
-		Addr: s.meta.RAddr,
-		Role: string(s.meta.Role),
+		ID:          s.meta.ID,
+		Addr:        s.meta.RAddr,
+		RPCAddr:     s.meta.RPCAddr,
+		Role:        string(s.meta.Role),
+		Revision:    applied,
+		Credentials: version,
+		Descendants: s.descendants(),
 	}
 	jsonBytes, err := json.Marshal(self)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
+	resp, err := s.peer.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return errors.New("register failure: replication loop")
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return errors.New("register failure: token rejected by master")
+	}
 	if resp.StatusCode != 200 {
 		return errors.New("register failure")
 	}
 
+	var registerResp types.RegisterResp
+	err = json.NewDecoder(resp.Body).Decode(&registerResp)
+	if err != nil {
+		return err
+	}
+	s.chain.Set(append([]string{s.meta.RAddr}, registerResp.Chain...))
+
+	return nil
+}
+
+//descendants addresses of the slaves replicating from a relay slave, directly or not
+func (s *Server) descendants() []string {
+	if !s.relay {
+		return nil
+	}
+
+	addrs := make([]string, 0)
+	var walk func(slaves []*types.ServerMetadata)
+	walk = func(slaves []*types.ServerMetadata) {
+		for _, sl := range slaves {
+			addrs = append(addrs, sl.RAddr)
+			walk(sl.Downstream)
+		}
+	}
+	s.mux.Lock()
+	walk(*s.slaves)
+	s.mux.Unlock()
+	return addrs
+}
+
+//catchUp fetch the changes since the last applied revision from master,
+//slave reports healthy only after it has caught up
+func (s *Server) catchUp() error {
+	url := s.peer.URL(s.masterAddr, "/changes")
+
+	applied, _, err := replication.Applied(s.store)
+	if err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(&types.ChangesReq{Revision: applied})
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.peer.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return errors.New("catch up failure")
+	}
+
+	var req types.SyncConfigReq
+	err = json.NewDecoder(resp.Body).Decode(&req)
+	if err != nil {
+		return err
+	}
+	changes, err := replication.Apply(s.store, &req)
+	if err != nil {
+		return err
+	}
+
+	for _, ev := range replication.Events(changes) {
+		s.trigger.Emit(ev)
+	}
+	if s.relay && len(changes) != 0 {
+		s.trigger.Emit(&event.Event{Type: event.SyncConfig})
+	}
+
+	s.status.SetReady(true)
+	s.logger.Info("Caught up with master revision:[%s] changes:[%s] snapshot:[%t]", color.Green(req.Revision), color.Green(len(changes)), req.Snapshot)
 	return nil
 }
 
 func (s *Server) unregister() error {
-	url := fmt.Sprintf("http://%s/unregister", s.masterAddr)
+	url := s.peer.URL(s.masterAddr, "/unregister")
 
 	self := &types.SlaveMetaReq{
 		ID:   s.meta.ID,
@@ -302,7 +682,7 @@ func (s *Server) unregister() error {
 		return err
 	}
 
-	resp, err := http.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
+	resp, err := s.peer.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
 	if err != nil {
 		return err
 	}
@@ -314,58 +694,91 @@ func (s *Server) unregister() error {
 	return nil
 }
 
-func (s *Server) healthCheck() {
-	duration := time.Second * 10
-	t := time.NewTimer(duration)
+//healthCheck check slaves every interval until stop is closed, a slave failing FailureThreshold
+//checks in a row becomes suspect, and is evicted as dead once it stays suspect longer than the grace period
+func (s *Server) healthCheck(stop <-chan struct{}) {
+	t := time.NewTicker(s.healthCfg.Interval)
+	defer t.Stop()
 	for {
-		t.Reset(duration)
-		<-t.C
-		g := sync.WaitGroup{}
-		g.Add(len(*s.slaves))
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		s.mux.Lock()
+		slaves := make([]*types.ServerMetadata, len(*s.slaves))
+		copy(slaves, *s.slaves)
+		s.mux.Unlock()
 
-		var checkFailureCount int
-		ids := make([]uuid.UUID, 0)
-		for idx, slave := range *s.slaves {
+		results := make([]*types.HealthResp, len(slaves))
+		g := sync.WaitGroup{}
+		g.Add(len(slaves))
+		for idx, slave := range slaves {
+			s.mux.Lock()
+			target := *slave
+			s.mux.Unlock()
 			go func(i int, sl *types.ServerMetadata) {
 				defer g.Done()
-				url := fmt.Sprintf("http://%s/health?id=%s", sl.RAddr, sl.ID)
-				var resp *http.Response
-				var err error
-				err = retry.Retry(3, func() error {
-					resp, err = http.Get(url)
-					if err != nil {
-						return err
-					}
-					return nil
-				}, 1*time.Second)
-				if err != nil || resp.StatusCode != 200 {
-					s.logger.Info("Slave id:[%s] addr:[%s] health check failure", color.Green(sl.ID), color.Green(sl.RAddr))
-					ids = append(ids, sl.ID)
-					sl.ID = uuid.Nil
-					checkFailureCount++
+				ctx, cancel := context.WithTimeout(context.Background(), s.healthCfg.Timeout)
+				defer cancel()
+				resp, err := s.checker.Check(ctx, sl)
+				if err != nil {
+					s.logger.Info("Slave id:[%s] addr:[%s] health check failure: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
+					return
 				}
-			}(idx, slave)
+				results[i] = resp
+			}(idx, &target)
 		}
 		g.Wait()
 
-		if checkFailureCount != 0 {
-			for _, id := range ids {
-				key := fmt.Sprintf(types.SlaveFormat, id.String())
-				_ = s.store.Delete([]byte(key))
+		now := time.Now()
+		transitions := make([]*types.HealthEvent, 0)
+		dead := make(map[*types.ServerMetadata]bool)
+		s.mux.Lock()
+		for i, sl := range slaves {
+			if results[i] != nil {
+				sl.Clients = results[i].Clients
+				sl.RPCAddr = results[i].RPCAddr
+				sl.Credentials = results[i].Credentials
+				sl.Downstream = results[i].Downstream
 			}
-
-			s.mux.Lock()
-			slaves := make([]*types.ServerMetadata, 0, len(*s.slaves))
-			for _, slave := range *s.slaves {
-				if slave.ID != uuid.Nil {
-					slaves = append(slaves, slave)
+			ev := health.Observe(s.healthCfg, sl, results[i] != nil, now)
+			if ev == nil {
+				continue
+			}
+			transitions = append(transitions, ev)
+			if ev.To == types.HealthDead {
+				dead[sl] = true
+			}
+		}
+		evicted := make([]*types.ServerMetadata, 0, len(dead))
+		if len(dead) != 0 {
+			remain := make([]*types.ServerMetadata, 0, len(*s.slaves))
+			for _, sl := range *s.slaves {
+				if dead[sl] {
+					evicted = append(evicted, sl)
+					continue
 				}
+				remain = append(remain, sl)
 			}
-			*s.slaves = slaves
-			s.mux.Unlock()
+			*s.slaves = remain
 		}
-
+		events := append(*s.healthEvents, transitions...)
+		if len(events) > types.HealthEventsCap {
+			events = events[len(events)-types.HealthEventsCap:]
+		}
+		*s.healthEvents = events
 		s.printSlaves()
+		s.mux.Unlock()
+
+		for _, sl := range evicted {
+			key := fmt.Sprintf(types.SlaveFormat, sl.ID.String())
+			_ = s.store.Delete([]byte(key))
+		}
+		for _, ev := range transitions {
+			s.trigger.Emit(&event.Event{Type: event.SlaveHealth, Body: map[string]interface{}{"event": ev}})
+		}
 	}
 }
 
@@ -398,65 +811,292 @@ func (s *Server) loadSlaves() {
 	}
 }
 
-func (s *Server) execEvent() {
-	for ev := range s.trigger.C() {
-		_ = s.eventHandlers[ev.Type](ev.Body)
+func (s *Server) execEvent(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case ev := <-s.trigger.C():
+			_ = s.eventHandlers[ev.Type](ev.Body)
+		}
 	}
 }
 
+//eventSyncHandler sync the slaves outside the event loop, so that a hung slave doesn't hold up
+//the events and the writes emitting them
 func (s *Server) eventSyncHandler(param map[string]interface{}) error {
-	g := sync.WaitGroup{}
-	g.Add(len(*s.slaves))
+	for _, id := range s.slaveIDs() {
+		id := id
+		s.background("sync/"+id.String(), func() {
+			s.syncConfig(id)
+		})
+	}
+	return nil
+}
+
+//slaveIDs ids of the slaves, raft peers are left out until their first heartbeat tells their revision
+func (s *Server) slaveIDs() []uuid.UUID {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	ids := make([]uuid.UUID, 0, len(*s.slaves))
 	for _, slave := range *s.slaves {
-		go func(sl *types.ServerMetadata) {
-			defer g.Done()
-			err := retry.Retry(3, func() error {
-				url := fmt.Sprintf("http://%s/sync", sl.RAddr)
+		if slave.ID != uuid.Nil {
+			ids = append(ids, slave.ID)
+		}
+	}
+	return ids
+}
 
-				req := &types.SyncConfigReq{
-					Datum: make([]*types.PushConfigReq, 0),
-				}
-				pairs, err := s.store.Items("config/")
-				if err != nil {
-					return err
-				}
-				for _, kv := range pairs {
-					var pr types.PushConfigReq
-					sKey := string(kv.Key)
-					splitKey := strings.Split(sKey, "#")
-					reg := regexp.MustCompile("config/(.*)/")
-					pr.Name = reg.FindStringSubmatch(splitKey[0])[1]
-					for _, v := range splitKey[1:] {
-						pr.Tag = append(pr.Tag, v)
-					}
-					pr.Body = string(kv.Value)
-					req.Datum = append(req.Datum, &pr)
-				}
+//slave slave of id, nil if it is gone or this node no longer replicates to it
+func (s *Server) slave(id uuid.UUID) *types.ServerMetadata {
+	if s.consensus != nil && !s.consensus.IsLeader() {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, slave := range *s.slaves {
+		if slave.ID == id {
+			return slave
+		}
+	}
+	return nil
+}
 
-				jsonBytes, err := json.Marshal(req)
-				if err != nil {
-					return err
-				}
+//background run f in its own goroutine unless a run of key is running, which then runs f once more
+//after it. runs of one key are serialized and requests made meanwhile are coalesced
+func (s *Server) background(key string, f func()) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, running := s.running[key]; running {
+		s.running[key] = true
+		return
+	}
+	s.running[key] = false
+	go func() {
+		for {
+			f()
+			s.mux.Lock()
+			again := s.running[key]
+			if again {
+				s.running[key] = false
+			} else {
+				delete(s.running, key)
+			}
+			s.mux.Unlock()
+			if !again {
+				return
+			}
+		}
+	}()
+}
 
-				resp, err := http.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
-				if err != nil {
-					return err
-				}
+//syncConfig sync the slave of id with retries
+func (s *Server) syncConfig(id uuid.UUID) {
+	sl := s.slave(id)
+	if sl == nil {
+		return
+	}
+	err := retry.Retry(3, func() error {
+		return s.syncSlave(sl)
+	})
+	if err != nil {
+		s.logger.Info("Slave id:[%s] addr:[%s] sync error: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
+		return
+	}
+	s.mux.Lock()
+	revision := sl.Revision
+	s.mux.Unlock()
+	s.logger.Info("Slave id:[%s] addr:[%s] sync success revision:[%s]", color.Green(sl.ID), color.Green(sl.RAddr), color.Green(revision))
+}
 
-				if resp.StatusCode != 200 {
-					return errors.New("register failure")
-				}
-				return nil
-			})
+//syncSlave send the changes since the slave's last applied revision
+func (s *Server) syncSlave(sl *types.ServerMetadata) error {
+	url := s.peer.URL(sl.RAddr, "/sync")
 
-			if err != nil {
-				s.logger.Info("Slave id:[%s] addr:[%s] sync error: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
-				return
-			}
-			s.logger.Info("Slave id:[%s] addr:[%s] sync success", color.Green(sl.ID), color.Green(sl.RAddr))
-		}(slave)
+	s.mux.Lock()
+	revision, term := sl.Revision, sl.Term
+	s.mux.Unlock()
+
+	req, err := replication.For(s.store, revision, term)
+	if err != nil {
+		return err
+	}
+	if !req.Snapshot && len(req.Datum) == 0 {
+		return nil
+	}
+	if s.consensus != nil {
+		req.Term = s.consensus.Term()
+	}
+
+	jsonBytes, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.peer.Post(url, "application:json", strings.NewReader(string(jsonBytes)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var syncResp types.SyncConfigResp
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusConflict {
+		err = json.NewDecoder(resp.Body).Decode(&syncResp)
+		if err != nil {
+			return err
+		}
+		s.mux.Lock()
+		sl.Revision = syncResp.Revision
+		sl.Term = syncResp.Term
+		s.mux.Unlock()
+		s.acks.Notify()
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("slave is at revision %d", syncResp.Revision)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("sync failure")
+	}
+	return nil
+}
+
+//onLeader take the raft peers as slaves, they are synced after their first heartbeat
+func (s *Server) onLeader(term uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	slaves := make([]*types.ServerMetadata, 0, len(s.consensus.Peers()))
+	for _, peer := range s.consensus.Peers() {
+		slaves = append(slaves, &types.ServerMetadata{Role: types.RoleSlave, RAddr: peer})
+	}
+	*s.slaves = slaves
+}
+
+//onFollower drop the slaves, the new leader syncs them
+func (s *Server) onFollower(term uint64) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	*s.slaves = make([]*types.ServerMetadata, 0)
+}
+
+//onHeartbeat track the revision of a raft peer and sync it if it is behind
+func (s *Server) onHeartbeat(addr string, resp *types.AppendResp) {
+	current, term, err := replication.Applied(s.store)
+	if err != nil {
+		return
+	}
+	version, err := credential.Version(s.store)
+	if err != nil {
+		return
+	}
+
+	lagging, stale := false, false
+	s.mux.Lock()
+	for _, sl := range *s.slaves {
+		if sl.RAddr != addr {
+			continue
+		}
+		if sl.ID == uuid.Nil {
+			s.logger.Info("Peer id:[%s] addr:[%s] revision:[%s] online", color.Green(resp.ID), color.Green(addr), color.Green(resp.Revision))
+		}
+		sl.ID = resp.ID
+		sl.Revision = resp.Revision
+		sl.Term = resp.LastTerm
+		sl.LastHealthCheck = time.Now().Unix()
+		sl.Clients = resp.Clients
+		sl.RPCAddr = resp.RPCAddr
+		sl.Credentials = resp.Credentials
+		lagging = resp.Revision != current || resp.LastTerm != term
+		stale = resp.Credentials != version
+		break
+	}
+	s.mux.Unlock()
+	s.acks.Notify()
+
+	if lagging {
+		s.trigger.TryEmit(&event.Event{Type: event.SyncConfig})
+	}
+	if stale {
+		s.trigger.TryEmit(&event.Event{Type: event.SyncCredentials})
+	}
+}
+
+func (s *Server) eventHealthHandler(param map[string]interface{}) error {
+	ev := param["event"].(*types.HealthEvent)
+	s.logger.Info("Slave id:[%s] addr:[%s] health [%s] -> [%s] failures:[%s]", color.Green(ev.ID), color.Green(ev.Addr), color.Green(ev.From), color.Green(ev.To), color.Green(ev.Failures))
+	return nil
+}
+
+//eventSyncCredentialsHandler push the credential set to the slaves holding another version of it,
+//outside the event loop like eventSyncHandler
+func (s *Server) eventSyncCredentialsHandler(param map[string]interface{}) error {
+	for _, id := range s.slaveIDs() {
+		id := id
+		s.background("credentials/"+id.String(), func() {
+			s.syncCredentials(id)
+		})
+	}
+	return nil
+}
+
+//syncCredentials push the credential set to the slave of id with retries if it holds another version of it
+func (s *Server) syncCredentials(id uuid.UUID) {
+	sl := s.slave(id)
+	if sl == nil {
+		return
+	}
+	req, err := credential.Snapshot(s.store)
+	if err != nil {
+		s.logger.Error("Slave id:[%s] addr:[%s] sync credentials error: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
+		return
+	}
+	s.mux.Lock()
+	current := sl.Credentials
+	s.mux.Unlock()
+	if current == req.Version {
+		return
+	}
+	jsonBytes, err := json.Marshal(req)
+	if err != nil {
+		s.logger.Error("Slave id:[%s] addr:[%s] sync credentials error: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
+		return
+	}
+
+	err = retry.Retry(3, func() error {
+		resp, err := s.peer.Post(s.peer.URL(sl.RAddr, "/credentials/sync"), "application:json", strings.NewReader(string(jsonBytes)))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return errors.New("sync credentials failure")
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Info("Slave id:[%s] addr:[%s] sync credentials error: %s", color.Green(sl.ID), color.Green(sl.RAddr), err)
+		return
+	}
+	s.mux.Lock()
+	sl.Credentials = req.Version
+	s.mux.Unlock()
+	s.logger.Info("Slave id:[%s] addr:[%s] sync credentials success version:[%s]", color.Green(sl.ID), color.Green(sl.RAddr), color.Green(req.Version))
+}
+
+//eventRevokeHandler close the rpc connections authenticated by a revoked or regranted credential
+func (s *Server) eventRevokeHandler(param map[string]interface{}) error {
+	revoked := param["app"].(string)
+	// clients are stopped after ForEach, stopping one removes it from the server under the same lock
+	clients := make([]*arpc.Client, 0)
+	s.psServer.ForEach(func(c *arpc.Client) {
+		if app, _ := rpchandler.App(c); app == revoked {
+			clients = append(clients, c)
+		}
+	})
+	for _, c := range clients {
+		s.rpcLogger.Info("%s Client addr:[%s] app:[%s] disconnected, credential revoked", s.psServer.Handler.LogTag(), color.Green(c.Conn.RemoteAddr()), color.Green(revoked))
+		c.Stop()
 	}
-	g.Wait()
 	return nil
 }
 
@@ -1,51 +1,211 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Jarnpher553/gonfig/internal/server/history"
 	"github.com/Jarnpher553/gonfig/internal/server/types"
 	"github.com/Jarnpher553/gonfig/internal/store"
+	"github.com/satori/go.uuid"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
 	leveldbStore, _ := store.NewLeveldbStore(store.StorageMem)
 	t.Run("NewMaster_1", func(t *testing.T) {
-		server := New(&types.ServerCfg{
+		server, err := New(&types.ServerCfg{
 			Addr: ":8888",
 			Role: types.RoleMaster,
-		}, leveldbStore)
-		if server == nil {
+		}, &Options{Store: leveldbStore})
+		if err != nil || server == nil {
 			t.FailNow()
 		}
 	})
 
 	t.Run("NewMaster_2", func(t *testing.T) {
-		server := New(&types.ServerCfg{
+		server, err := New(&types.ServerCfg{
 			Addr: ":8888",
 			Role: types.RoleMaster,
-		}, store.NewMapStore())
-		if server == nil {
+		}, &Options{Store: store.NewMapStore()})
+		if err != nil || server == nil {
 			t.FailNow()
 		}
 	})
 
 	t.Run("NewSlave_1", func(t *testing.T) {
-		server := New(&types.ServerCfg{
+		server, err := New(&types.ServerCfg{
 			Addr:       ":7777",
 			Role:       types.RoleSlave,
 			MasterAddr: "127.0.0.1:8888",
-		}, leveldbStore)
-		if server == nil {
+		}, &Options{Store: leveldbStore})
+		if err != nil || server == nil {
 			t.FailNow()
 		}
 	})
 
 	t.Run("NewSlave_2", func(t *testing.T) {
-		server := New(&types.ServerCfg{
+		server, err := New(&types.ServerCfg{
 			Addr:       ":7777",
 			Role:       types.RoleSlave,
 			MasterAddr: "127.0.0.1:8888",
-		}, store.NewMapStore())
-		if server == nil {
+		}, &Options{Store: store.NewMapStore()})
+		if err != nil || server == nil {
+			t.FailNow()
+		}
+		// slaves replay revisions, they serve them like configs
+		routed := false
+		for _, r := range server.httpRouters {
+			routed = routed || r.URL == "/revisions"
+		}
+		if !routed {
+			t.FailNow()
+		}
+	})
+
+	t.Run("NewDefaultStore", func(t *testing.T) {
+		wd, _ := os.Getwd()
+		defer os.Chdir(wd)
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		// the default store is closed if New fails and by Shutdown, leveldb locks the directory until then
+		if _, err := New(&types.ServerCfg{Addr: "8888", Role: types.RoleMaster}, nil); err == nil {
+			t.FailNow()
+		}
+		server, err := New(&types.ServerCfg{Addr: ":8888", Role: types.RoleMaster}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		leveldbStore, err := store.NewLeveldbStore(store.StorageFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leveldbStore.Close()
+	})
+}
+
+func TestRelay(t *testing.T) {
+	var got types.SlaveMetaReq
+	status := http.StatusOK
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(&types.RegisterResp{Chain: []string{"10.0.0.1:9019"}})
+	}))
+	defer master.Close()
+
+	s, err := New(&types.ServerCfg{
+		Addr:       "10.0.0.2:8888",
+		Role:       types.RoleSlave,
+		MasterAddr: master.Listener.Addr().String(),
+		Relay:      true,
+	}, &Options{Store: store.NewMapStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	*s.slaves = append(*s.slaves,
+		&types.ServerMetadata{RAddr: "10.0.0.3:8888", Downstream: []*types.ServerMetadata{
+			{RAddr: "10.0.0.4:8888", Downstream: []*types.ServerMetadata{{RAddr: "10.0.0.5:8888"}}},
+		}},
+		&types.ServerMetadata{RAddr: "10.0.0.6:8888"},
+	)
+
+	tests := []struct {
+		status int
+		ok     bool
+		chain  string
+	}{
+		{http.StatusConflict, false, "10.0.0.2:8888"},
+		{http.StatusForbidden, false, "10.0.0.2:8888"},
+		{http.StatusOK, true, "10.0.0.2:8888,10.0.0.1:9019"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Relay_%d", i), func(t *testing.T) {
+			status = test.status
+			err := s.register()
+			if (err == nil) != test.ok || strings.Join(s.chain.Addrs(), ",") != test.chain {
+				t.FailNow()
+			}
+			// the master rejects a relay whose subtree holds one of its upstreams
+			if strings.Join(got.Descendants, ",") != "10.0.0.3:8888,10.0.0.4:8888,10.0.0.5:8888,10.0.0.6:8888" {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestBackground(t *testing.T) {
+	s, err := New(&types.ServerCfg{Addr: "127.0.0.1:8888", Role: types.RoleMaster}, &Options{Store: store.NewMapStore()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Background_Coalesce", func(t *testing.T) {
+		release := make(chan struct{})
+		runs := make(chan int, 10)
+		n := 0
+		f := func() {
+			n++
+			runs <- n
+			<-release
+		}
+		s.background("k", f)
+		<-runs
+		// requests while the first run is running are coalesced into one more run
+		for i := 0; i < 3; i++ {
+			s.background("k", f)
+		}
+		close(release)
+		if <-runs != 2 {
+			t.FailNow()
+		}
+		time.Sleep(50 * time.Millisecond)
+		s.mux.Lock()
+		_, running := s.running["k"]
+		s.mux.Unlock()
+		if running || len(runs) != 0 {
+			t.FailNow()
+		}
+	})
+
+	t.Run("Background_HungSlave", func(t *testing.T) {
+		hung := make(chan struct{})
+		slave := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-hung
+		}))
+		defer slave.Close()
+		defer close(hung)
+		if _, err := history.Commit(s.store, types.ConfigKey("web", nil), "v1", "alice", nil); err != nil {
+			t.Fatal(err)
+		}
+		s.mux.Lock()
+		*s.slaves = append(*s.slaves, &types.ServerMetadata{ID: uuid.NewV4(), RAddr: slave.Listener.Addr().String()})
+		s.mux.Unlock()
+
+		// a hung slave holds up neither the event loop nor the writes emitting events
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 10; i++ {
+				s.eventSyncHandler(nil)
+				s.eventSyncCredentialsHandler(nil)
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
 			t.FailNow()
 		}
 	})
@@ -0,0 +1,43 @@
+package types
+
+import "sync"
+
+//Chain addresses of a node and its upstreams up to master, a slave whose
+//subtree holds one of them would make a replication loop
+type Chain struct {
+	mux   sync.RWMutex
+	addrs []string
+}
+
+//NewChain construct Chain of addrs
+func NewChain(addrs ...string) *Chain {
+	return &Chain{addrs: addrs}
+}
+
+//Set replace the chain after registering to upstream
+func (c *Chain) Set(addrs []string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.addrs = addrs
+}
+
+//Addrs addresses from the node up to master
+func (c *Chain) Addrs() []string {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return append([]string(nil), c.addrs...)
+}
+
+//Contains whether any of addrs is on the chain
+func (c *Chain) Contains(addrs ...string) bool {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	for _, a := range addrs {
+		for _, b := range c.addrs {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
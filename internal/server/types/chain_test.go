@@ -0,0 +1,41 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	chain := NewChain("10.0.0.3:8888", "10.0.0.2:8888", "10.0.0.1:9019")
+	tests := []struct {
+		addrs    []string
+		contains bool
+	}{
+		{nil, false},
+		{[]string{"10.0.0.4:8888"}, false},
+		{[]string{"10.0.0.3:8888"}, true},
+		{[]string{"10.0.0.1:9019"}, true},
+		{[]string{"10.0.0.4:8888", "10.0.0.5:8888", "10.0.0.2:8888"}, true},
+		{[]string{"10.0.0.1:9020"}, false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Chain_%d", i), func(t *testing.T) {
+			if chain.Contains(test.addrs...) != test.contains {
+				t.FailNow()
+			}
+		})
+	}
+
+	t.Run("Chain_Set", func(t *testing.T) {
+		c := NewChain("10.0.0.3:8888")
+		addrs := c.Addrs()
+		addrs[0] = "10.0.0.9:8888"
+		if c.Contains("10.0.0.9:8888") {
+			t.FailNow()
+		}
+		c.Set([]string{"10.0.0.3:8888", "10.0.0.1:9019"})
+		if !c.Contains("10.0.0.1:9019") || len(c.Addrs()) != 2 {
+			t.FailNow()
+		}
+	})
+}
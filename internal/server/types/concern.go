@@ -0,0 +1,64 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+//WriteConcern slave acknowledgements a write waits for before master responds
+type WriteConcern string
+
+const (
+	//WriteLocal respond once master has stored the write, raft mode takes it as WriteMajority
+	WriteLocal = "local"
+	//WriteMajority wait until a majority of the cluster, master included, has applied the write
+	WriteMajority = "majority"
+	//WriteAll wait until every slave has applied the write
+	WriteAll = "all"
+)
+
+//WriteConcernTimeout max duration a write waits for slave acknowledgements
+var WriteConcernTimeout = 5 * time.Second
+
+//Valid whether write concern is known, empty means local
+func (c WriteConcern) Valid() bool {
+	return c == "" || c == WriteLocal || c == WriteMajority || c == WriteAll
+}
+
+//Acks needed slave acknowledgements out of slaves
+func (c WriteConcern) Acks(slaves int) int {
+	switch c {
+	case WriteMajority:
+		return (slaves + 1) / 2
+	case WriteAll:
+		return slaves
+	default:
+		return 0
+	}
+}
+
+//Acks broadcast slave acknowledgements to writes waiting on their write concern
+type Acks struct {
+	mux     sync.Mutex
+	changed chan struct{}
+}
+
+//NewAcks construct Acks
+func NewAcks() *Acks {
+	return &Acks{changed: make(chan struct{})}
+}
+
+//Notify wake up waiting writes after a slave's applied revision has changed
+func (a *Acks) Notify() {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+//Changed closed on the next Notify
+func (a *Acks) Changed() <-chan struct{} {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.changed
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWriteConcern(t *testing.T) {
+	tests := []struct {
+		concern WriteConcern
+		valid   bool
+		slaves  int
+		acks    int
+	}{
+		{"", true, 3, 0},
+		{WriteLocal, true, 3, 0},
+		{WriteMajority, true, 0, 0},
+		{WriteMajority, true, 1, 1},
+		{WriteMajority, true, 2, 1},
+		{WriteMajority, true, 3, 2},
+		{WriteMajority, true, 4, 2},
+		{WriteAll, true, 0, 0},
+		{WriteAll, true, 3, 3},
+		{"2", false, 3, 0},
+		{"quorum", false, 3, 0},
+		{"ALL", false, 3, 0},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("WriteConcern_%d", i), func(t *testing.T) {
+			if test.concern.Valid() != test.valid || test.concern.Acks(test.slaves) != test.acks {
+				t.FailNow()
+			}
+		})
+	}
+}
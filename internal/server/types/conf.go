@@ -1,8 +1,100 @@
 package types
 
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+//Mode cluster mode
+type Mode string
+
+const (
+	//ModeStatic fixed master/slave roles
+	ModeStatic = "static"
+	//ModeRaft nodes elect a leader which acts as master
+	ModeRaft = "raft"
+)
+
 //ServerCfg Server's config
 type ServerCfg struct {
 	Addr       string
 	Role       Role
 	MasterAddr string
+	Mode       Mode
+	//RPCAddr listen address of the rpc server, the port next to Addr's if empty,
+	//the host of Addr is advertised if it has none
+	RPCAddr string
+	//Peers addresses of the other nodes in raft mode
+	Peers []string
+	//Relay slave accepts downstream slaves and fans out the changes it receives
+	Relay bool
+	//Health health check settings of slaves, defaults are used if nil
+	Health *HealthCfg
+	//Password shared password rpc clients may authenticate with besides credentials,
+	//PubSubPassword is accepted while no credential exists if empty
+	Password string
+	//Token cluster token the nodes send each other, the http api requires a token or a
+	//credential with the role of the route when it is set
+	Token string
+	//ReadTimeout of http requests, 30 seconds if zero
+	ReadTimeout time.Duration
+	//WriteTimeout of http responses, 30 seconds if zero
+	WriteTimeout time.Duration
+	//MaxBodyBytes max size of request bodies on the routes of clients, DefaultMaxBodyBytes if zero
+	//and no limit if negative. routes between nodes are not limited
+	MaxBodyBytes int64
+	//CORSOrigins origins browsers may call the http api from, "*" allows any, cors is off if empty
+	CORSOrigins []string
+}
+
+//Validate check the config is consistent
+func (c *ServerCfg) Validate() error {
+	if c.Mode != ModeStatic && c.Mode != ModeRaft {
+		return errors.New("mode hasn't be static or raft")
+	}
+	if c.Role != RoleMaster && c.Role != RoleSlave {
+		return errors.New("role hasn't be master or slave")
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return errors.New("server address format error")
+	}
+	if c.RPCAddr != "" {
+		if _, _, err := net.SplitHostPort(c.RPCAddr); err != nil {
+			return errors.New("rpc address format error")
+		}
+	}
+	if c.Mode == ModeRaft {
+		if c.Role != RoleMaster {
+			return errors.New("raft node must be master")
+		}
+		if len(c.Peers) == 0 {
+			return errors.New("peers of raft node can't be empty")
+		}
+		for _, peer := range c.Peers {
+			if _, _, err := net.SplitHostPort(peer); err != nil {
+				return errors.New("peer address format error")
+			}
+		}
+	}
+	if c.Relay && c.Role != RoleSlave {
+		return errors.New("relay can only be set on slave")
+	}
+	if c.Role == RoleSlave {
+		if c.MasterAddr == "" {
+			return errors.New("master address of slave peer can't be empty")
+		}
+		if _, _, err := net.SplitHostPort(c.MasterAddr); err != nil {
+			return errors.New("master address format error")
+		}
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		return errors.New("http timeouts can't be negative")
+	}
+	if c.Health != nil {
+		if err := c.Health.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -7,4 +7,45 @@ const (
 	ConfigFormat = "config/%s/#%s"
 	//SlaveFormat slave store format
 	SlaveFormat = "slave/%s"
+	//RevisionFormat config revision store format, keyed by config key and revision number
+	RevisionFormat = "revision/%s/%020d"
+	//RevisionHeadFormat latest revision number of a config key
+	RevisionHeadFormat = "head/%s"
+	//ListLimit default page size of config listing
+	ListLimit = 100
+	//ListMaxLimit max page size of config listing
+	ListMaxLimit = 1000
+	//DefaultMaxBodyBytes default max size of request bodies on the routes of clients
+	DefaultMaxBodyBytes = 4 << 20
+	//TombstoneFormat topic of config deletion
+	TombstoneFormat = "tombstone/%s/#%s"
+	//GlobalRevisionKey store key of the global revision, on slaves the last one applied
+	GlobalRevisionKey = "meta/revision"
+	//LastTermKey store key of the raft term of the global revision
+	LastTermKey = "meta/term"
+	//ChangelogFormat config change store format, keyed by global revision
+	ChangelogFormat = "changelog/%020d"
+	//ChangelogRetention changelog entries kept for incremental sync, slaves further behind get a snapshot
+	ChangelogRetention = 1000
+	//RaftTermKey store key of the current raft term
+	RaftTermKey = "raft/term"
+	//RaftVoteKey store key of the candidate voted for in the current raft term
+	RaftVoteKey = "raft/vote"
+	//CredentialFormat credential store format, keyed by application
+	CredentialFormat = "credential/%s"
+	//CredentialsVersionKey store key of the version of the credential set
+	CredentialsVersionKey = "meta/credentials"
+	//AuditFormat audit entry store format, keyed by sequence number
+	AuditFormat = "audit/%020d"
+	//AuditSequenceKey store key of the sequence number of the last audit entry
+	AuditSequenceKey = "meta/audit"
+	//AppKey rpc client value holding the application the client authenticated as,
+	//empty for the shared password
+	AppKey = "gonfig.app"
+	//LeaderHeader response header carrying the raft leader's address
+	LeaderHeader = "X-Gonfig-Leader"
+	//ForwardedHeader request header listing the nodes a write was forwarded through
+	ForwardedHeader = "X-Gonfig-Forwarded"
+	//RequestIDHeader request and response header carrying the id of a http request
+	RequestIDHeader = "X-Request-ID"
 )
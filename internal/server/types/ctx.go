@@ -1,8 +1,10 @@
 package types
 
 import (
+	"context"
 	"github.com/Jarnpher553/gonfig/internal/logger"
 	"github.com/Jarnpher553/gonfig/internal/server/event"
+	"github.com/Jarnpher553/gonfig/internal/server/peer"
 	"github.com/Jarnpher553/gonfig/internal/store"
 	"sync"
 )
@@ -14,5 +16,65 @@ type ServiceCtx struct {
 	Store   store.Store
 	Mux     *sync.Mutex
 	Trigger event.Trigger
-	Logger  *logger.XLogger
+	Logger  logger.Logger
+	Status  *Status
+	//Master address of master, empty on master
+	Master string
+	//Acks notified when a slave's applied revision changes
+	Acks *Acks
+	//Clients count of connected rpc clients
+	Clients func() int
+	//Authenticate application authenticated by key, empty for the shared password
+	Authenticate func(key string) (string, bool)
+	//Token cluster token which has every role on the http api, the api is open if empty and no credential exists
+	Token string
+	//Metrics request counts and latencies of the http routes
+	Metrics *Metrics
+	//Chain addresses from the node up to master
+	Chain *Chain
+	//AntiEntropy metrics of anti-entropy rounds, guarded by Mux
+	AntiEntropy *AntiEntropyStatus
+	//HealthEvents recent health transitions of slaves, guarded by Mux
+	HealthEvents *[]*HealthEvent
+	//Peer http client between nodes
+	Peer *peer.Client
+	//MutualTLS nodes present certificates to each other, requests between nodes without one are rejected
+	MutualTLS bool
+	//Consensus raft node, nil in static mode
+	Consensus Consensus
+}
+
+//Consensus raft node used by http handler
+type Consensus interface {
+	Vote(req *VoteReq) *VoteResp
+	Append(req *AppendReq) *AppendResp
+	Term() uint64
+	IsLeader() bool
+	Leader() string
+}
+
+type appKey struct{}
+
+//WithApp context of a http request authenticated as app, empty for the cluster token
+func WithApp(ctx context.Context, app string) context.Context {
+	return context.WithValue(ctx, appKey{}, app)
+}
+
+//App application a http request was authenticated as, ok is false if the http api is open
+func App(ctx context.Context) (string, bool) {
+	app, ok := ctx.Value(appKey{}).(string)
+	return app, ok
+}
+
+type requestIDKey struct{}
+
+//WithRequestID context of a http request with id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+//RequestID id of a http request, empty if it has none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
 }
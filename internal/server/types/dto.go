@@ -4,16 +4,46 @@ import uuid "github.com/satori/go.uuid"
 
 //SlaveMetaReq slave register request body
 type SlaveMetaReq struct {
-	ID   uuid.UUID
-	Addr string
-	Role string
+	ID       uuid.UUID
+	Addr     string
+	Role     string
+	Revision uint64
+	//RPCAddr advertised address of the slave's rpc server
+	RPCAddr string `json:",omitempty"`
+	//Credentials version of the credential set the slave holds
+	Credentials uint64 `json:",omitempty"`
+	//Descendants addresses of the slaves replicating from a relay slave, directly or not
+	Descendants []string `json:",omitempty"`
+}
+
+//RegisterResp register slave response body
+type RegisterResp struct {
+	//Chain addresses of the upstream and its own upstreams up to master
+	Chain []string
 }
 
 //PushConfigReq push config request body
 type PushConfigReq struct {
-	Tag  []string
-	Name string
-	Body string
+	Tag    []string
+	Name   string
+	Body   string
+	Author string
+	//ExpectedRevision reject the push if the config's latest revision differs, zero means config must not exist
+	ExpectedRevision *uint64 `json:",omitempty"`
+	//ExpectedHash reject the push if the config's latest body hash differs
+	ExpectedHash string `json:",omitempty"`
+	//WriteConcern local, majority or all, default local
+	WriteConcern WriteConcern `json:",omitempty"`
+}
+
+//PushConfigResp push config response body
+type PushConfigResp struct {
+	Revision uint64
+	Hash     string
+	//Acked slaves which applied the write
+	Acked []string `json:",omitempty"`
+	//Lagging slaves which had not applied the write when the write concern timed out
+	Lagging []string `json:",omitempty"`
 }
 
 //PullConfigReq pull config request body
@@ -27,7 +57,275 @@ type PullConfigResp struct {
 	Body string
 }
 
+//HeartbeatResp slave heartbeat response body
+type HeartbeatResp struct {
+	//Revision global revision of master
+	Revision uint64
+}
+
+//HealthResp slave health check response body
+type HealthResp struct {
+	ID       uuid.UUID
+	Revision uint64
+	Clients  int
+	RPCAddr  string `json:",omitempty"`
+	//Credentials version of the credential set the slave holds
+	Credentials uint64 `json:",omitempty"`
+	//Downstream slaves of a relay slave
+	Downstream []*ServerMetadata `json:",omitempty"`
+}
+
+//ClusterResp cluster status response body
+type ClusterResp struct {
+	Master      *NodeStatus
+	Slaves      []*NodeStatus
+	AntiEntropy AntiEntropyStatus
+	//Events recent health transitions of slaves, oldest first
+	Events []*HealthEvent `json:",omitempty"`
+}
+
+//AntiEntropyStatus metrics of anti-entropy rounds between master and slaves
+type AntiEntropyStatus struct {
+	//Rounds completed rounds
+	Rounds uint64
+	//LastRound unix time of the last round
+	LastRound int64 `json:",omitempty"`
+	//Divergent slave digests found differing from master's
+	Divergent uint64
+	//Repaired configs changed on slaves by repairs
+	Repaired uint64
+}
+
+//NodeStatus status of a node in the cluster
+type NodeStatus struct {
+	ServerMetadata
+	//CurrentTerm raft term the leader is in, zero in static mode and for the other nodes
+	CurrentTerm uint64 `json:",omitempty"`
+	//Lag global revisions the node is behind master
+	Lag uint64
+	//Downstream slaves replicating from a relay slave
+	Downstream []*NodeStatus `json:",omitempty"`
+}
+
+//Digest hash tree of a node's config keyspace, Nodes[0] is the root and
+//the children of node i are 2i+1 and 2i+2, empty hash for empty subtrees
+type Digest struct {
+	//Revision global revision the digest was taken at
+	Revision uint64
+	Nodes    []string
+}
+
+//RepairReq anti-entropy repair request body, Datum is master's full config set
+//of Buckets and replaces the slave's configs in them
+type RepairReq struct {
+	//Revision global revision of master the datum were read at, the slave must have applied it
+	//and nothing later, or a sync landing after the digest would be reverted
+	Revision uint64
+	Buckets  []int
+	Datum    []*ConfigChange
+}
+
+//RepairResp anti-entropy repair response body
+type RepairResp struct {
+	//Repaired configs the slave changed
+	Repaired int
+}
+
 //SyncConfigReq sync config request body
 type SyncConfigReq struct {
-	Datum []*PushConfigReq
+	//Term raft term of the leader sending the changes, zero in static mode
+	Term uint64 `json:",omitempty"`
+	//From global revision the changes are based on
+	From uint64
+	//Revision global revision after applying the changes
+	Revision uint64
+	//LastTerm raft term of Revision
+	LastTerm uint64 `json:",omitempty"`
+	//Snapshot Datum is the full config set and replaces the slave's store
+	Snapshot bool
+	Datum    []*ConfigChange
+}
+
+//SyncConfigResp sync config response body
+type SyncConfigResp struct {
+	//Revision last global revision applied by the slave
+	Revision uint64
+	//Term raft term of the revision
+	Term uint64
+}
+
+//ChangesReq changes since revision request body
+type ChangesReq struct {
+	Revision uint64
+}
+
+//ConfigChange config value or tombstone synced to slaves
+type ConfigChange struct {
+	Revision uint64 `json:",omitempty"`
+	Term     uint64 `json:",omitempty"`
+	Name     string
+	Tag      []string
+	Body     string
+	Deleted  bool `json:",omitempty"`
+	//History config revision the change made, its Body is left empty
+	History *ConfigRevision `json:",omitempty"`
+	//Audit audit log entry of the write which made the change, replayed along with it
+	Audit *AuditEntry `json:",omitempty"`
+}
+
+//DeleteConfigReq delete config request body
+type DeleteConfigReq struct {
+	Name             string
+	Tag              []string
+	Author           string
+	ExpectedRevision *uint64      `json:",omitempty"`
+	ExpectedHash     string       `json:",omitempty"`
+	WriteConcern     WriteConcern `json:",omitempty"`
+}
+
+//RevisionsReq list config revisions request body
+type RevisionsReq struct {
+	Name string
+	Tag  []string
+}
+
+//RevisionsResp list config revisions response body
+type RevisionsResp struct {
+	Revisions []*ConfigRevision
+}
+
+//RollbackReq rollback config request body
+type RollbackReq struct {
+	Name         string
+	Tag          []string
+	Revision     uint64
+	Author       string
+	WriteConcern WriteConcern `json:",omitempty"`
+}
+
+//ListConfigReq list configs request body
+type ListConfigReq struct {
+	//Prefix config name prefix
+	Prefix string
+	//Tags configs must have all of these tags
+	Tags []string
+	//Limit max configs of a page
+	Limit int
+	//Cursor Next of the previous page
+	Cursor string
+}
+
+//ListConfigResp list configs response body
+type ListConfigResp struct {
+	Configs []*ConfigSummary
+	//Next cursor of the next page, empty if there is no more config
+	Next string
+}
+
+//AuditReq query audit log request body, the filters of an export too
+type AuditReq struct {
+	//Name glob pattern of config names as of path.Match, any config if empty
+	Name string
+	//Tags configs must have all of these tags
+	Tags []string
+	//Since entries recorded at or after this unix time, no lower bound if zero
+	Since int64
+	//Until entries recorded before this unix time, no upper bound if zero
+	Until int64
+	//Limit max entries of a page, ignored by export
+	Limit int
+	//Cursor Next of the previous page
+	Cursor uint64
+}
+
+//AuditResp query audit log response body, entries are in the order they were recorded
+type AuditResp struct {
+	Entries []*AuditEntry
+	//Next cursor of the next page, zero if there is no more entry
+	Next uint64
+}
+
+//ConfigSummary config listing item
+type ConfigSummary struct {
+	Name      string
+	Tag       []string
+	Size      int
+	Revision  uint64
+	Hash      string
+	Timestamp int64
+	Author    string
+}
+
+//VoteReq raft request vote body
+type VoteReq struct {
+	Term      uint64
+	Candidate string
+	Revision  uint64
+	LastTerm  uint64
+}
+
+//VoteResp raft request vote response body
+type VoteResp struct {
+	Term    uint64
+	Granted bool
+}
+
+//AppendReq raft heartbeat body
+type AppendReq struct {
+	Term   uint64
+	Leader string
+	//Revision global revision of the leader
+	Revision uint64
+	//LastTerm raft term of Revision
+	LastTerm uint64
+}
+
+//AppendResp raft heartbeat response body
+type AppendResp struct {
+	Term     uint64
+	Success  bool
+	ID       uuid.UUID
+	Revision uint64
+	LastTerm uint64
+	Clients  int
+	RPCAddr  string `json:",omitempty"`
+	//Credentials version of the credential set the node holds
+	Credentials uint64 `json:",omitempty"`
+}
+
+//CreateCredentialReq create credential request body
+type CreateCredentialReq struct {
+	App    string
+	Grants []*Grant
+	Roles  []Access
+}
+
+//CreateCredentialResp create credential response body, Key is only shown once
+type CreateCredentialResp struct {
+	App string
+	Key string
+}
+
+//GrantReq replace the grants of a credential request body, its roles too unless Roles is nil
+type GrantReq struct {
+	App    string
+	Grants []*Grant
+	Roles  []Access
+}
+
+//RevokeCredentialReq revoke credential request body
+type RevokeCredentialReq struct {
+	App string
+}
+
+//CredentialsResp list credentials response body
+type CredentialsResp struct {
+	Version     uint64
+	Credentials []*Credential
+}
+
+//SyncCredentialsReq sync credentials request body, Credentials replaces the slave's set
+type SyncCredentialsReq struct {
+	Version     uint64
+	Credentials []*Credential
 }
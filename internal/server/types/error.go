@@ -0,0 +1,50 @@
+package types
+
+import "net/http"
+
+//ErrorCode machine readable code of a failed http request
+type ErrorCode string
+
+const (
+	//CodeInvalidRequest malformed request body, parameter or method
+	CodeInvalidRequest = "invalid_request"
+	//CodeUnauthorized missing or unknown credential
+	CodeUnauthorized = "unauthorized"
+	//CodeForbidden credential lacks the role or the grant
+	CodeForbidden = "forbidden"
+	//CodeNotFound config, revision, slave or credential not found
+	CodeNotFound = "not_found"
+	//CodeConflict request conflicts with the current state, e.g. the config moved on
+	CodeConflict = "conflict"
+	//CodeUnavailable node can't serve the request now, e.g. master is unknown or unreachable
+	CodeUnavailable = "unavailable"
+	//CodeInternal failure of the node, details are only logged
+	CodeInternal = "internal"
+)
+
+//ErrorResp error response body of the http api
+type ErrorResp struct {
+	Code    ErrorCode
+	Message string
+	//RequestID id of the request, the X-Request-ID response header too
+	RequestID string `json:",omitempty"`
+}
+
+//ErrorCodeOf code of an error response with status
+func ErrorCodeOf(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusRequestEntityTooLarge:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"errors"
+	uuid "github.com/satori/go.uuid"
+	"time"
+)
+
+//HealthState health state of a slave as seen by its master
+type HealthState string
+
+const (
+	//HealthAlive the last health check succeeded
+	HealthAlive HealthState = "Alive"
+	//HealthSuspect health checks failed FailureThreshold times in a row
+	HealthSuspect HealthState = "Suspect"
+	//HealthDead slave stayed suspect longer than the grace period, it is evicted
+	HealthDead HealthState = "Dead"
+)
+
+//HealthEventsCap count of recent health transitions kept for the cluster status
+const HealthEventsCap = 32
+
+//HealthCfg health check settings of master
+type HealthCfg struct {
+	//Interval between two checks of a slave
+	Interval time.Duration
+	//Timeout of a single check
+	Timeout time.Duration
+	//FailureThreshold consecutive failed checks before a slave is suspect
+	FailureThreshold int
+	//Grace duration a slave stays suspect before it is declared dead and evicted
+	Grace time.Duration
+}
+
+//DefaultHealthCfg default health check settings
+func DefaultHealthCfg() *HealthCfg {
+	return &HealthCfg{
+		Interval:         10 * time.Second,
+		Timeout:          3 * time.Second,
+		FailureThreshold: 3,
+		Grace:            30 * time.Second,
+	}
+}
+
+//Validate check the settings are usable
+func (c *HealthCfg) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("health check interval must be positive")
+	}
+	if c.Timeout <= 0 || c.Timeout > c.Interval {
+		return errors.New("health check timeout must be positive and not longer than interval")
+	}
+	if c.FailureThreshold < 1 {
+		return errors.New("health check failure threshold must be at least 1")
+	}
+	if c.Grace < 0 {
+		return errors.New("health check grace can't be negative")
+	}
+	return nil
+}
+
+//HealthEvent transition of a slave's health state
+type HealthEvent struct {
+	ID       uuid.UUID
+	Addr     string
+	From     HealthState
+	To       HealthState
+	Failures int
+	//Time unix time of the transition
+	Time int64
+}
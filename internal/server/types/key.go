@@ -0,0 +1,30 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+//ConfigKey config store key of name and tags
+func ConfigKey(name string, tags []string) string {
+	return fmt.Sprintf(ConfigFormat, name, strings.Join(tags, "#"))
+}
+
+//TombstoneKey deletion topic of a config store key
+func TombstoneKey(key string) string {
+	name, tags := ParseConfigKey(key)
+	return fmt.Sprintf(TombstoneFormat, name, strings.Join(tags, "#"))
+}
+
+//ParseConfigKey split config or tombstone store key into name and tags, tags are nil for a config without any
+func ParseConfigKey(key string) (string, []string) {
+	splitKey := strings.Split(key, "#")
+	name := strings.TrimSuffix(splitKey[0], "/")
+	for _, prefix := range []string{"config/", "tombstone/"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if len(splitKey) == 2 && splitKey[1] == "" {
+		return name, nil
+	}
+	return name, splitKey[1:]
+}
@@ -0,0 +1,36 @@
+package types
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestConfigKey(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		key  string
+	}{
+		{"web", nil, "config/web/#"},
+		{"web", []string{"prod"}, "config/web/#prod"},
+		{"web", []string{"prod", "eu"}, "config/web/#prod#eu"},
+		{"billing-api", []string{"a:b", "c"}, "config/billing-api/#a:b#c"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("ConfigKey_%d", i), func(t *testing.T) {
+			key := ConfigKey(test.name, test.tags)
+			if key != test.key {
+				t.FailNow()
+			}
+			name, tags := ParseConfigKey(key)
+			if name != test.name || !reflect.DeepEqual(tags, test.tags) {
+				t.FailNow()
+			}
+			name, tags = ParseConfigKey(TombstoneKey(key))
+			if name != test.name || !reflect.DeepEqual(tags, test.tags) {
+				t.FailNow()
+			}
+		})
+	}
+}
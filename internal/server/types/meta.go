@@ -11,6 +11,20 @@ const (
 	RoleSlave = "Slave"
 )
 
+//Access role of a credential on the http api
+type Access string
+
+const (
+	//AccessReader pull, list and inspect the configs granted, credentials without roles are readers
+	AccessReader = "reader"
+	//AccessWriter push, rollback and delete the configs granted, implies reader
+	AccessWriter = "writer"
+	//AccessAdmin manage credentials and read and write any config, implies every other role
+	AccessAdmin = "admin"
+	//AccessReplica requests between nodes, e.g. registering a slave and syncing configs to it
+	AccessReplica = "replica"
+)
+
 //Slaves slaves
 type Slaves = []*ServerMetadata
 
@@ -20,6 +34,87 @@ type ServerMetadata struct {
 	Role  Role
 	LAddr string
 	RAddr string
+	//RPCAddr advertised address of the rpc server clients subscribe to
+	RPCAddr string `json:",omitempty"`
+	//Revision last global revision applied by a slave
+	Revision uint64
+	//Term raft term of the slave's last applied revision
+	Term uint64
+	//LastHealthCheck unix time of the slave's last successful health check
+	LastHealthCheck int64 `json:",omitempty"`
+	//Failures consecutive failed health checks
+	Failures int
+	//State health state of a slave, empty until its first health check
+	State HealthState `json:",omitempty"`
+	//SuspectSince unix time the slave became suspect
+	SuspectSince int64 `json:",omitempty"`
+	//Clients rpc clients connected to the node
+	Clients int
+	//Repaired configs anti-entropy repaired on the slave
+	Repaired uint64 `json:",omitempty"`
+	//Credentials version of the credential set the slave holds
+	Credentials uint64 `json:",omitempty"`
+	//Downstream slaves of a relay slave, as last reported by its health check
+	Downstream []*ServerMetadata `json:",omitempty"`
+}
+
+//Credential application allowed to connect to the rpc server and to call the http api
+type Credential struct {
+	App string
+	//Hash hex encoded sha256 of the application's secret, left empty when listed
+	Hash    string `json:",omitempty"`
+	Created int64
+	//Grants configs the application may read, and write as a writer, none if empty
+	Grants []*Grant `json:",omitempty"`
+	//Roles of the application on the http api, a reader if empty
+	Roles []Access `json:",omitempty"`
+}
+
+//Grant access to the configs whose name matches Name and which have all of Tags
+type Grant struct {
+	//Name glob pattern of config names as of path.Match, e.g. "billing-*"
+	Name string
+	Tags []string `json:",omitempty"`
+	//Access AccessWriter lets the writer push, roll back and delete the matching configs, they are only read otherwise
+	Access Access `json:",omitempty"`
+}
+
+//AuditOp write operation recorded in the audit log
+type AuditOp string
+
+const (
+	//AuditPush config pushed
+	AuditPush = "push"
+	//AuditRollback config rolled back to an earlier revision
+	AuditRollback = "rollback"
+	//AuditDelete config deleted
+	AuditDelete = "delete"
+)
+
+//AuditTokenActor actor of writes authenticated with the cluster token, no application is named so
+const AuditTokenActor = "@token"
+
+//AuditEntry config write recorded in the audit log
+type AuditEntry struct {
+	//Seq sequence number in the audit log of the node, entries replayed by a slave get the slave's next one
+	Seq       uint64
+	Timestamp int64
+	Op        AuditOp
+	Name      string
+	Tag       []string
+	//Actor application of the credential the request was authenticated with, AuditTokenActor
+	//for the cluster token, empty if the http api is open
+	Actor string `json:",omitempty"`
+	//Author author the request named, the client address if it named none. it is not verified
+	Author string
+	//IP source address of the request, the original client's for writes forwarded by a node
+	IP string
+	//Revision config revision the write created
+	Revision uint64
+	//PrevHash body hash before the write, empty if the config didn't exist
+	PrevHash string `json:",omitempty"`
+	//Hash body hash after the write, empty for a delete
+	Hash string `json:",omitempty"`
 }
 
 //ConfigMetadata config metadata
@@ -27,3 +122,21 @@ type ConfigMetadata struct {
 	Name string
 	Tags []string
 }
+
+//ConfigRevision immutable revision of a config
+type ConfigRevision struct {
+	Revision  uint64
+	Timestamp int64
+	Author    string
+	Hash      string
+	Body      string
+	Deleted   bool `json:",omitempty"`
+	//Global global revision of the commit, only set on the revision a commit returns
+	Global uint64 `json:"-"`
+}
+
+//Precondition expected state of a config before it is written, a nil Revision or an empty Hash is not checked
+type Precondition struct {
+	Revision *uint64
+	Hash     string
+}
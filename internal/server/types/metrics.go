@@ -0,0 +1,114 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//LatencyBuckets upper bounds in seconds of the http latency histogram
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//Metrics request counts and latencies of the http routes
+type Metrics struct {
+	mux    sync.Mutex
+	routes map[string]*routeMetrics
+}
+
+type routeMetrics struct {
+	codes map[int]uint64
+	//buckets counts per upper bound of LatencyBuckets, not cumulative
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+//NewMetrics construct Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{routes: make(map[string]*routeMetrics)}
+}
+
+//Observe record a request to the route of path answered with code after d
+func (m *Metrics) Observe(path string, code int, d time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	rm, ok := m.routes[path]
+	if !ok {
+		rm = &routeMetrics{codes: make(map[int]uint64), buckets: make([]uint64, len(LatencyBuckets))}
+		m.routes[path] = rm
+	}
+	rm.codes[code]++
+	seconds := d.Seconds()
+	for i, le := range LatencyBuckets {
+		if seconds <= le {
+			rm.buckets[i]++
+			break
+		}
+	}
+	rm.count++
+	rm.sum += seconds
+}
+
+//WriteTo write the metrics to w in the prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	paths := make([]string, 0, len(m.routes))
+	for path := range m.routes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var n int64
+	write := func(format string, v ...interface{}) error {
+		c, err := fmt.Fprintf(w, format, v...)
+		n += int64(c)
+		return err
+	}
+
+	if err := write("# HELP gonfig_http_requests_total Http requests by route and status code.\n# TYPE gonfig_http_requests_total counter\n"); err != nil {
+		return n, err
+	}
+	for _, path := range paths {
+		rm := m.routes[path]
+		codes := make([]int, 0, len(rm.codes))
+		for code := range rm.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			if err := write("gonfig_http_requests_total{path=%q,code=\"%d\"} %d\n", path, code, rm.codes[code]); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	if err := write("# HELP gonfig_http_request_duration_seconds Http request latency by route.\n# TYPE gonfig_http_request_duration_seconds histogram\n"); err != nil {
+		return n, err
+	}
+	for _, path := range paths {
+		rm := m.routes[path]
+		var cumulative uint64
+		for i, le := range LatencyBuckets {
+			cumulative += rm.buckets[i]
+			if err := write("gonfig_http_request_duration_seconds_bucket{path=%q,le=%q} %d\n", path, strconv.FormatFloat(le, 'g', -1, 64), cumulative); err != nil {
+				return n, err
+			}
+		}
+		if err := write("gonfig_http_request_duration_seconds_bucket{path=%q,le=\"+Inf\"} %d\n", path, rm.count); err != nil {
+			return n, err
+		}
+		if err := write("gonfig_http_request_duration_seconds_sum{path=%q} %s\n", path, strconv.FormatFloat(rm.sum, 'g', -1, 64)); err != nil {
+			return n, err
+		}
+		if err := write("gonfig_http_request_duration_seconds_count{path=%q} %d\n", path, rm.count); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
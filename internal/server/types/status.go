@@ -0,0 +1,22 @@
+package types
+
+import "sync/atomic"
+
+//Status server readiness
+type Status struct {
+	ready int32
+}
+
+//SetReady mark server ready or not
+func (s *Status) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+//Ready whether server is ready to serve
+func (s *Status) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
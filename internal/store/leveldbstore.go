@@ -1,16 +1,20 @@
 package store
 
 import (
+	"bytes"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"sync"
 )
 
 //LeveldbStore store use leveldb
 type LeveldbStore struct {
-	DB *leveldb.DB
+	DB     *leveldb.DB
+	mux    sync.Mutex
+	commit sync.Mutex
 }
 
 const (
@@ -28,7 +32,7 @@ func NewLeveldbStore(t StorageType) (*LeveldbStore, error) {
 	if t == StorageMem {
 		db, err = leveldb.Open(storage.NewMemStorage(), nil)
 	} else {
-		db, err = leveldb.OpenFile("./db", nil)
+		return OpenLeveldbStore("./db")
 	}
 	if err != nil {
 		return nil, err
@@ -36,8 +40,24 @@ func NewLeveldbStore(t StorageType) (*LeveldbStore, error) {
 	return &LeveldbStore{DB: db}, nil
 }
 
+//OpenLeveldbStore leveldb store persisted in dir
+func OpenLeveldbStore(dir string) (*LeveldbStore, error) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LeveldbStore{DB: db}, nil
+}
+
+//Close close the leveldb database, which releases its lock on the directory
+func (store *LeveldbStore) Close() error {
+	return store.DB.Close()
+}
+
 //Put set key/value
 func (store *LeveldbStore) Put(k []byte, v []byte) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
 	return store.DB.Put(k, v, &opt.WriteOptions{
 		Sync: true,
 	})
@@ -45,11 +65,17 @@ func (store *LeveldbStore) Put(k []byte, v []byte) error {
 
 //Get get key/value
 func (store *LeveldbStore) Get(k []byte) ([]byte, error) {
-	return store.DB.Get(k, nil)
+	v, err := store.DB.Get(k, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return v, err
 }
 
 //Delete delete key/value
 func (store *LeveldbStore) Delete(k []byte) error {
+	store.mux.Lock()
+	defer store.mux.Unlock()
 	return store.DB.Delete(k, &opt.WriteOptions{
 		Sync: true,
 	})
@@ -83,3 +109,27 @@ func (store *LeveldbStore) Items(prefix ...string) ([]*KeyValuePair, error) {
 	}
 	return out, nil
 }
+
+//CompareAndSwap set key/value if current value equals old
+func (store *LeveldbStore) CompareAndSwap(k []byte, old []byte, v []byte) (bool, error) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	cur, err := store.DB.Get(k, nil)
+	if err == leveldb.ErrNotFound {
+		if old != nil {
+			return false, nil
+		}
+	} else if err != nil {
+		return false, err
+	} else if old == nil || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	return true, store.DB.Put(k, v, &opt.WriteOptions{
+		Sync: true,
+	})
+}
+
+//CommitLock lock serializing the commits on the store
+func (store *LeveldbStore) CommitLock() *sync.Mutex {
+	return &store.commit
+}
@@ -0,0 +1,35 @@
+package store
+
+import "testing"
+
+func TestLeveldbStore_CompareAndSwap(t *testing.T) {
+	s, err := NewLeveldbStore(StorageMem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("LeveldbStore_CompareAndSwap_Absent", func(t *testing.T) {
+		ok, _ := s.CompareAndSwap([]byte("key1"), nil, []byte("value1"))
+		if !ok {
+			t.FailNow()
+		}
+		ok, _ = s.CompareAndSwap([]byte("key1"), nil, []byte("value2"))
+		if ok {
+			t.FailNow()
+		}
+	})
+	t.Run("LeveldbStore_CompareAndSwap_Equal", func(t *testing.T) {
+		ok, _ := s.CompareAndSwap([]byte("key1"), []byte("value1"), []byte("value2"))
+		v, _ := s.Get([]byte("key1"))
+		if !ok || string(v) != "value2" {
+			t.FailNow()
+		}
+	})
+	t.Run("LeveldbStore_CompareAndSwap_NotEqual", func(t *testing.T) {
+		ok, _ := s.CompareAndSwap([]byte("key1"), []byte("value1"), []byte("value3"))
+		v, _ := s.Get([]byte("key1"))
+		if ok || string(v) != "value2" {
+			t.FailNow()
+		}
+	})
+}
@@ -1,7 +1,7 @@
 package store
 
 import (
-	"errors"
+	"bytes"
 	"strings"
 	"sync"
 	"unsafe"
@@ -9,8 +9,9 @@ import (
 
 //MapStore store use map
 type MapStore struct {
-	store map[string]string
-	mux   *sync.RWMutex
+	store  map[string]string
+	mux    *sync.RWMutex
+	commit sync.Mutex
 }
 
 //NewMapStore 新建字典存储
@@ -35,7 +36,7 @@ func (m *MapStore) Get(key []byte) ([]byte, error) {
 	k := *(*string)(unsafe.Pointer(&key))
 	v, ok := m.store[k]
 	if !ok {
-		return nil, errors.New("no item of key")
+		return nil, ErrNotFound
 	}
 	return *(*[]byte)(unsafe.Pointer(&v)), nil
 }
@@ -68,3 +69,25 @@ func (m *MapStore) Items(prefix ...string) ([]*KeyValuePair, error) {
 	}
 	return out, nil
 }
+
+//CompareAndSwap set key/value if current value equals old
+func (m *MapStore) CompareAndSwap(key []byte, old []byte, val []byte) (bool, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	k := string(key)
+	v, ok := m.store[k]
+	if old == nil {
+		if ok {
+			return false, nil
+		}
+	} else if !ok || !bytes.Equal([]byte(v), old) {
+		return false, nil
+	}
+	m.store[k] = string(val)
+	return true, nil
+}
+
+//CommitLock lock serializing the commits on the store
+func (m *MapStore) CommitLock() *sync.Mutex {
+	return &m.commit
+}
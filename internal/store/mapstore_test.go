@@ -146,3 +146,37 @@ func TestNewMapStore_Items(t *testing.T) {
 		}
 	})
 }
+
+func TestMapStore_CompareAndSwap(t *testing.T) {
+	s := NewMapStore()
+
+	t.Run("MapStore_CompareAndSwap_Absent", func(t *testing.T) {
+		ok, _ := s.CompareAndSwap([]byte("key1"), nil, []byte("value1"))
+		if !ok {
+			t.FailNow()
+		}
+		ok, _ = s.CompareAndSwap([]byte("key1"), nil, []byte("value2"))
+		if ok {
+			t.FailNow()
+		}
+	})
+	t.Run("MapStore_CompareAndSwap_Equal", func(t *testing.T) {
+		ok, _ := s.CompareAndSwap([]byte("key1"), []byte("value1"), []byte("value2"))
+		if !ok || s.store["key1"] != "value2" {
+			t.FailNow()
+		}
+	})
+	t.Run("MapStore_CompareAndSwap_NotEqual", func(t *testing.T) {
+		ok, _ := s.CompareAndSwap([]byte("key1"), []byte("value1"), []byte("value3"))
+		if ok || s.store["key1"] != "value2" {
+			t.FailNow()
+		}
+	})
+}
+
+func TestCommitLock(t *testing.T) {
+	a, b := NewMapStore(), NewMapStore()
+	if a.CommitLock() != a.CommitLock() || a.CommitLock() == b.CommitLock() {
+		t.FailNow()
+	}
+}
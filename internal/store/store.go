@@ -1,5 +1,13 @@
 package store
 
+import (
+	"errors"
+	"sync"
+)
+
+//ErrNotFound key doesn't exist in the store
+var ErrNotFound = errors.New("no item of key")
+
 type KeyValuePair struct {
 	Key   []byte
 	Value []byte
@@ -7,7 +15,15 @@ type KeyValuePair struct {
 
 type Store interface {
 	Put([]byte, []byte) error
+	// Get value of key, it fails with ErrNotFound if key doesn't exist
 	Get([]byte) ([]byte, error)
 	Delete([]byte) error
 	Items(prefix ...string) ([]*KeyValuePair, error)
+	// CompareAndSwap set key to new value only if its current value equals old,
+	// a nil old means key must not exist
+	CompareAndSwap(k []byte, old []byte, new []byte) (bool, error)
+	// CommitLock lock serializing the read-modify-write sequences on the store, such as allocating
+	// revision or sequence numbers, it must be the same for the life of the store and must not
+	// block the other operations
+	CommitLock() *sync.Mutex
 }
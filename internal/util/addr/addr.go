@@ -3,6 +3,8 @@ package addr
 import (
 	"fmt"
 	"net"
+	"os"
+	"strconv"
 	"strings"
 )
 
@@ -78,3 +80,53 @@ func ParseIP(addr string) (string, error) {
 
 	return "", fmt.Errorf("no ip address found, and explicit ip not provided")
 }
+
+//RPC listen and advertised address of the rpc server, rpcAddr defaults to the port next to the http
+//server's, and an empty or unspecified host, e.g. 0.0.0.0, to the advertised host of the http server
+func RPC(advertised string, rpcAddr string) (string, string, error) {
+	host, port, err := net.SplitHostPort(advertised)
+	if err != nil {
+		return "", "", err
+	}
+	if rpcAddr == "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return "", "", err
+		}
+		rpcAddr = net.JoinHostPort("", strconv.Itoa(p+1))
+	}
+	rpcHost, rpcPort, err := net.SplitHostPort(rpcAddr)
+	if err != nil {
+		return "", "", err
+	}
+	if rpcHost == "" || net.ParseIP(rpcHost).IsUnspecified() {
+		rpcHost = host
+	}
+	return rpcAddr, net.JoinHostPort(rpcHost, rpcPort), nil
+}
+
+//Advertise address other nodes reach a server listening on addr at, an empty or
+//unspecified host is taken from GONFIG_HOST_IP or the local interfaces
+func Advertise(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host != "" {
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return "", fmt.Errorf("ip format error: %s", host)
+		}
+		if !ip.IsUnspecified() {
+			return addr, nil
+		}
+	}
+	if envIP := os.Getenv("GONFIG_HOST_IP"); envIP != "" {
+		return net.JoinHostPort(envIP, port), nil
+	}
+	ip, err := ParseIP(addr)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip, port), nil
+}
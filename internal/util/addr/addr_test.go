@@ -0,0 +1,71 @@
+package addr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestRPC(t *testing.T) {
+	tests := []struct {
+		advertised string
+		rpcAddr    string
+		listen     string
+		rpc        string
+		ok         bool
+	}{
+		{"10.0.0.1:9019", "", ":9020", "10.0.0.1:9020", true},
+		{"10.0.0.1:9019", ":7001", ":7001", "10.0.0.1:7001", true},
+		{"10.0.0.1:9019", "0.0.0.0:7001", "0.0.0.0:7001", "10.0.0.1:7001", true},
+		{"10.0.0.1:9019", "[::]:7001", "[::]:7001", "10.0.0.1:7001", true},
+		{"10.0.0.1:9019", "10.0.1.1:7001", "10.0.1.1:7001", "10.0.1.1:7001", true},
+		{"10.0.0.1:9019", "7001", "", "", false},
+		{"10.0.0.1", "", "", "", false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("RPC_%d", i), func(t *testing.T) {
+			listen, rpc, err := RPC(test.advertised, test.rpcAddr)
+			if (err == nil) != test.ok || listen != test.listen || rpc != test.rpc {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	env, set := os.LookupEnv("GONFIG_HOST_IP")
+	defer func() {
+		if set {
+			os.Setenv("GONFIG_HOST_IP", env)
+		} else {
+			os.Unsetenv("GONFIG_HOST_IP")
+		}
+	}()
+	os.Setenv("GONFIG_HOST_IP", "10.0.0.9")
+
+	tests := []struct {
+		addr      string
+		advertise string
+		ok        bool
+	}{
+		{"10.0.0.1:9019", "10.0.0.1:9019", true},
+		{":9019", "10.0.0.9:9019", true},
+		{"0.0.0.0:9019", "10.0.0.9:9019", true},
+		{"localhost:9019", "", false},
+		{"9019", "", false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("Advertise_%d", i), func(t *testing.T) {
+			a, err := Advertise(test.addr)
+			if (err == nil) != test.ok || a != test.advertise {
+				t.FailNow()
+			}
+			if err == nil {
+				if _, _, err := net.SplitHostPort(a); err != nil {
+					t.FailNow()
+				}
+			}
+		})
+	}
+}
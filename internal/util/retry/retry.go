@@ -22,3 +22,26 @@ func Retry(count int, f func() error, d ...time.Duration) error {
 
 	return err
 }
+
+// Backoff retry to exec func until it succeeds or stop is closed,
+// the interval doubles from min up to max
+func Backoff(f func() error, min time.Duration, max time.Duration, stop <-chan struct{}) error {
+	duration := min
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-stop:
+			return err
+		case <-time.After(duration):
+		}
+
+		duration *= 2
+		if duration > max {
+			duration = max
+		}
+	}
+}
@@ -0,0 +1,298 @@
+//Package server embeddable gonfig node
+//
+//	s, err := server.New(server.WithAddr("127.0.0.1:9019"), server.WithStore(st))
+//	if err != nil {
+//		return err
+//	}
+//	if err := s.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer s.Shutdown(ctx)
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/Jarnpher553/gonfig/internal/logger"
+	internal "github.com/Jarnpher553/gonfig/internal/server"
+	"github.com/Jarnpher553/gonfig/internal/server/certs"
+	"github.com/Jarnpher553/gonfig/internal/server/health"
+	"github.com/Jarnpher553/gonfig/internal/server/middleware"
+	"github.com/Jarnpher553/gonfig/internal/server/types"
+	"github.com/Jarnpher553/gonfig/internal/store"
+	"github.com/Jarnpher553/gonfig/internal/util/addr"
+	"net/http"
+	"time"
+)
+
+//Store persists configs, CommitLock returns one lock per store which the server holds while it commits
+type Store = store.Store
+
+//KeyValuePair item of Store
+type KeyValuePair = store.KeyValuePair
+
+//ErrNotFound error of Store.Get for a key which doesn't exist, stores must return it
+var ErrNotFound = store.ErrNotFound
+
+//Logger leveled logger
+type Logger = logger.Logger
+
+//Role role of a node
+type Role = types.Role
+
+const (
+	//RoleMaster master accepts writes and replicates them to slaves
+	RoleMaster Role = types.RoleMaster
+	//RoleSlave slave replicates from master and forwards writes to it
+	RoleSlave Role = types.RoleSlave
+)
+
+//HealthChecker probe the health of a slave
+type HealthChecker = health.Checker
+
+//SlaveMetadata metadata of a slave passed to HealthChecker
+type SlaveMetadata = types.ServerMetadata
+
+//HealthResp health reported by a slave
+type HealthResp = types.HealthResp
+
+//Middleware wraps the http handler of every route
+type Middleware = func(next http.Handler) http.Handler
+
+//NewMemStore store kept in memory, configs are lost when the process exits
+func NewMemStore() Store {
+	return store.NewMapStore()
+}
+
+//NewLeveldbStore leveldb store persisted in dir
+func NewLeveldbStore(dir string) (Store, error) {
+	return store.OpenLeveldbStore(dir)
+}
+
+//Option configure a Server
+type Option func(o *options) error
+
+type options struct {
+	cfg  *types.ServerCfg
+	opts *internal.Options
+}
+
+//WithStore persist configs in st, a leveldb store in the working directory is used by default.
+//st is left open by Shutdown, the default store is closed
+func WithStore(st Store) Option {
+	return func(o *options) error {
+		if st == nil {
+			return errors.New("store is nil")
+		}
+		o.opts.Store = st
+		return nil
+	}
+}
+
+//WithAddr http listen address, ":9019" by default, an empty host is advertised as GONFIG_HOST_IP or the ip of a local interface
+func WithAddr(a string) Option {
+	return func(o *options) error {
+		o.cfg.Addr = a
+		return nil
+	}
+}
+
+//WithRPCAddr rpc listen address, the port next to the http one by default.
+//an empty host is advertised as the host of the http address
+func WithRPCAddr(a string) Option {
+	return func(o *options) error {
+		o.cfg.RPCAddr = a
+		return nil
+	}
+}
+
+//WithMaster run as master, the default role
+func WithMaster() Option {
+	return func(o *options) error {
+		o.cfg.Role = RoleMaster
+		o.cfg.MasterAddr = ""
+		return nil
+	}
+}
+
+//WithSlave run as slave of the master at masterAddr, a relay slave accepts slaves of its own
+func WithSlave(masterAddr string, relay bool) Option {
+	return func(o *options) error {
+		o.cfg.Role = RoleSlave
+		o.cfg.MasterAddr = masterAddr
+		o.cfg.Relay = relay
+		return nil
+	}
+}
+
+//WithRaft run as raft node with the other nodes at peers, the elected leader acts as master
+func WithRaft(peers ...string) Option {
+	return func(o *options) error {
+		o.cfg.Mode = types.ModeRaft
+		o.cfg.Role = RoleMaster
+		o.cfg.Peers = peers
+		return nil
+	}
+}
+
+//WithLogger log through l, the rpc server logs through it too as arpc's process wide logger
+func WithLogger(l Logger) Option {
+	return func(o *options) error {
+		if l == nil {
+			return errors.New("logger is nil")
+		}
+		o.opts.Logger = l
+		return nil
+	}
+}
+
+//WithPassword shared password rpc clients may authenticate with besides credentials, a built-in
+//default is accepted while no credential exists if empty
+func WithPassword(password string) Option {
+	return func(o *options) error {
+		o.cfg.Password = password
+		return nil
+	}
+}
+
+//WithToken cluster token shared by the nodes, the http api requires it or a credential with
+//the role of the route when it is set
+func WithToken(token string) Option {
+	return func(o *options) error {
+		o.cfg.Token = token
+		return nil
+	}
+}
+
+//WithTLS serve both listeners over tls, requests to the other nodes use cfg as client config,
+//so a cfg with Certificates, RootCAs and ClientCAs gives mutual tls between nodes: requests
+//between nodes without a certificate signed by ClientCAs are rejected
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) error {
+		if cfg == nil {
+			return errors.New("tls config is nil")
+		}
+		o.opts.TLS = cfg
+		return nil
+	}
+}
+
+//WithTLSFiles serve both listeners over tls with the certificate and key files, they are
+//reloaded when they change. a ca file, which may be empty, gives mutual tls between nodes
+func WithTLSFiles(certFile string, keyFile string, caFile string) Option {
+	return func(o *options) error {
+		reloader, err := certs.New(certFile, keyFile, caFile)
+		if err != nil {
+			return err
+		}
+		o.opts.Certs = reloader
+		return nil
+	}
+}
+
+//WithHealthCheck check slaves every interval with timeout, a slave failing failures checks in a row
+//is suspect, and is evicted as dead once it stays suspect longer than grace
+func WithHealthCheck(interval time.Duration, timeout time.Duration, failures int, grace time.Duration) Option {
+	return func(o *options) error {
+		o.cfg.Health = &types.HealthCfg{
+			Interval:         interval,
+			Timeout:          timeout,
+			FailureThreshold: failures,
+			Grace:            grace,
+		}
+		return o.cfg.Health.Validate()
+	}
+}
+
+//WithHealthChecker probe slaves with c instead of their /health endpoint
+func WithHealthChecker(c HealthChecker) Option {
+	return func(o *options) error {
+		if c == nil {
+			return errors.New("health checker is nil")
+		}
+		o.opts.Checker = c
+		return nil
+	}
+}
+
+//WithMiddleware wrap every http route with mws in order. they run after the built-in request
+//ids, logging, metrics, recovery, cors, method check and body limit, and before authentication,
+//so the request id is in the X-Request-ID header and panics are answered with 500
+func WithMiddleware(mws ...Middleware) Option {
+	return func(o *options) error {
+		for _, mw := range mws {
+			if mw == nil {
+				return errors.New("middleware is nil")
+			}
+			o.opts.Middleware = append(o.opts.Middleware, middleware.Func(mw))
+		}
+		return nil
+	}
+}
+
+//WithCORS allow browsers on origins to call the http api, "*" allows any origin
+func WithCORS(origins ...string) Option {
+	return func(o *options) error {
+		o.cfg.CORSOrigins = origins
+		return nil
+	}
+}
+
+//WithMaxBodyBytes max size of request bodies from clients, 4 MiB by default and no limit if
+//negative. requests between nodes are not limited
+func WithMaxBodyBytes(n int64) Option {
+	return func(o *options) error {
+		o.cfg.MaxBodyBytes = n
+		return nil
+	}
+}
+
+//Server embeddable gonfig node
+type Server struct {
+	s *internal.Server
+}
+
+//New construct Server, by default a static master listening on ":9019"
+func New(opts ...Option) (*Server, error) {
+	o := &options{
+		cfg: &types.ServerCfg{
+			Addr: ":9019",
+			Role: RoleMaster,
+			Mode: types.ModeStatic,
+		},
+		opts: &internal.Options{},
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+
+	a, err := addr.Advertise(o.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	o.cfg.Addr = a
+	if err := o.cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	s, err := internal.New(o.cfg, o.opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{s: s}, nil
+}
+
+//Start listen and start replicating, it returns once the server is serving,
+//ctx bounds the initial catch up of a slave with its master
+func (s *Server) Start(ctx context.Context) error {
+	return s.s.Start(ctx)
+}
+
+//Shutdown stop replicating, gracefully close both listeners and close the default store, a slave
+//unregisters from its master
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.s.Shutdown(ctx)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		opts []Option
+		ok   bool
+	}{
+		{[]Option{WithStore(NewMemStore()), WithAddr("127.0.0.1:19019")}, true},
+		{[]Option{WithStore(NewMemStore()), WithAddr("127.0.0.1:19029"), WithSlave("127.0.0.1:19019", true)}, true},
+		{[]Option{WithStore(NewMemStore()), WithAddr("127.0.0.1:19029"), WithSlave("", false)}, false},
+		{[]Option{WithStore(NewMemStore()), WithAddr("127.0.0.1")}, false},
+		{[]Option{WithStore(NewMemStore()), WithRaft()}, false},
+		{[]Option{WithStore(NewMemStore()), WithHealthCheck(time.Second, 2*time.Second, 3, 0)}, false},
+		{[]Option{WithStore(nil)}, false},
+	}
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("New_%d", i), func(t *testing.T) {
+			s, err := New(c.opts...)
+			if (err == nil) != c.ok || (s != nil) != c.ok {
+				t.FailNow()
+			}
+		})
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	s, err := New(WithStore(NewMemStore()), WithAddr("127.0.0.1:19039"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Lifecycle_Start", func(t *testing.T) {
+		if err := s.Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get("http://127.0.0.1:19039/cluster")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.FailNow()
+		}
+	})
+	t.Run("Lifecycle_AddrInUse", func(t *testing.T) {
+		s2, err := New(WithStore(NewMemStore()), WithAddr("127.0.0.1:19039"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s2.Start(ctx); err == nil {
+			t.FailNow()
+		}
+	})
+	t.Run("Lifecycle_Shutdown", func(t *testing.T) {
+		if err := s.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := http.Get("http://127.0.0.1:19039/cluster"); err == nil {
+			t.FailNow()
+		}
+		if err := s.Shutdown(ctx); err == nil {
+			t.FailNow()
+		}
+	})
+}